@@ -0,0 +1,210 @@
+package assertions
+
+import (
+	"slices"
+
+	"github.com/OctopusDeploy/go-octopusdeploy/v2/pkg/environments"
+	"github.com/OctopusDeploy/go-octopusdeploy/v2/pkg/feeds"
+	"github.com/OctopusDeploy/go-octopusdeploy/v2/pkg/lifecycles"
+	"github.com/OctopusDeploy/go-octopusdeploy/v2/pkg/projectgroups"
+	"github.com/OctopusDeploy/go-octopusdeploy/v2/pkg/tenants"
+)
+
+// EnvironmentAssertion checks an environment. The space assertions can be chained after it.
+type EnvironmentAssertion struct {
+	*SpaceAssertion
+	environment *environments.Environment
+}
+
+// HasEnvironment asserts the space has an environment with the supplied name
+func (s *SpaceAssertion) HasEnvironment(name string) *EnvironmentAssertion {
+	s.t.Helper()
+
+	items, err := environments.GetAll(s.client, s.spaceId)
+	environment := findByName(s, s.label(), "environment", name, items, err, func(item *environments.Environment) string {
+		return item.Name
+	})
+
+	return &EnvironmentAssertion{SpaceAssertion: s, environment: environment}
+}
+
+// WithDescription asserts the description of the environment
+func (a *EnvironmentAssertion) WithDescription(description string) *EnvironmentAssertion {
+	a.t.Helper()
+
+	if a.environment != nil {
+		a.expectField(describe("environment", a.environment.Name), "description", description, a.environment.Description)
+	}
+
+	return a
+}
+
+// WithGuidedFailure asserts whether guided failure is used by default in the environment
+func (a *EnvironmentAssertion) WithGuidedFailure(useGuidedFailure bool) *EnvironmentAssertion {
+	a.t.Helper()
+
+	if a.environment != nil {
+		a.expectField(describe("environment", a.environment.Name), "UseGuidedFailure", useGuidedFailure, a.environment.UseGuidedFailure)
+	}
+
+	return a
+}
+
+// WithDynamicInfrastructure asserts whether the environment allows dynamic infrastructure
+func (a *EnvironmentAssertion) WithDynamicInfrastructure(allowDynamicInfrastructure bool) *EnvironmentAssertion {
+	a.t.Helper()
+
+	if a.environment != nil {
+		a.expectField(describe("environment", a.environment.Name), "AllowDynamicInfrastructure", allowDynamicInfrastructure, a.environment.AllowDynamicInfrastructure)
+	}
+
+	return a
+}
+
+// ProjectGroupAssertion checks a project group. The space assertions can be chained after it.
+type ProjectGroupAssertion struct {
+	*SpaceAssertion
+	projectGroup *projectgroups.ProjectGroup
+}
+
+// HasProjectGroup asserts the space has a project group with the supplied name
+func (s *SpaceAssertion) HasProjectGroup(name string) *ProjectGroupAssertion {
+	s.t.Helper()
+
+	items, err := projectgroups.GetAll(s.client, s.spaceId)
+	projectGroup := findByName(s, s.label(), "project group", name, items, err, func(item *projectgroups.ProjectGroup) string {
+		return item.Name
+	})
+
+	return &ProjectGroupAssertion{SpaceAssertion: s, projectGroup: projectGroup}
+}
+
+// WithDescription asserts the description of the project group
+func (a *ProjectGroupAssertion) WithDescription(description string) *ProjectGroupAssertion {
+	a.t.Helper()
+
+	if a.projectGroup != nil {
+		a.expectField(describe("project group", a.projectGroup.Name), "description", description, a.projectGroup.Description)
+	}
+
+	return a
+}
+
+// LifecycleAssertion checks a lifecycle. The space assertions can be chained after it.
+type LifecycleAssertion struct {
+	*SpaceAssertion
+	lifecycle *lifecycles.Lifecycle
+}
+
+// HasLifecycle asserts the space has a lifecycle with the supplied name
+func (s *SpaceAssertion) HasLifecycle(name string) *LifecycleAssertion {
+	s.t.Helper()
+
+	items, err := lifecycles.GetAll(s.client, s.spaceId)
+	lifecycle := findByName(s, s.label(), "lifecycle", name, items, err, func(item *lifecycles.Lifecycle) string {
+		return item.Name
+	})
+
+	return &LifecycleAssertion{SpaceAssertion: s, lifecycle: lifecycle}
+}
+
+// WithDescription asserts the description of the lifecycle
+func (a *LifecycleAssertion) WithDescription(description string) *LifecycleAssertion {
+	a.t.Helper()
+
+	if a.lifecycle != nil {
+		a.expectField(describe("lifecycle", a.lifecycle.Name), "description", description, a.lifecycle.Description)
+	}
+
+	return a
+}
+
+// WithPhases asserts the names of the phases of the lifecycle, in order
+func (a *LifecycleAssertion) WithPhases(names ...string) *LifecycleAssertion {
+	a.t.Helper()
+
+	if a.lifecycle != nil {
+		phases := []string{}
+		for _, phase := range a.lifecycle.Phases {
+			phases = append(phases, phase.Name)
+		}
+
+		a.expectField(describe("lifecycle", a.lifecycle.Name), "phases", append([]string{}, names...), phases)
+	}
+
+	return a
+}
+
+// FeedAssertion checks a feed. The space assertions can be chained after it.
+type FeedAssertion struct {
+	*SpaceAssertion
+	feed feeds.IFeed
+}
+
+// HasFeed asserts the space has a feed with the supplied name
+func (s *SpaceAssertion) HasFeed(name string) *FeedAssertion {
+	s.t.Helper()
+
+	items, err := feeds.GetAll(s.client, s.spaceId)
+	feed := findByName(s, s.label(), "feed", name, items, err, func(item feeds.IFeed) string {
+		return item.GetName()
+	})
+
+	return &FeedAssertion{SpaceAssertion: s, feed: feed}
+}
+
+// WithFeedType asserts the type of the feed, such as feeds.FeedTypeDocker
+func (a *FeedAssertion) WithFeedType(feedType feeds.FeedType) *FeedAssertion {
+	a.t.Helper()
+
+	if a.feed != nil {
+		a.expectField(describe("feed", a.feed.GetName()), "feed type", feedType, a.feed.GetFeedType())
+	}
+
+	return a
+}
+
+// TenantAssertion checks a tenant. The space assertions can be chained after it.
+type TenantAssertion struct {
+	*SpaceAssertion
+	tenant *tenants.Tenant
+}
+
+// HasTenant asserts the space has a tenant with the supplied name
+func (s *SpaceAssertion) HasTenant(name string) *TenantAssertion {
+	s.t.Helper()
+
+	items, err := tenants.GetAll(s.client, s.spaceId)
+	tenant := findByName(s, s.label(), "tenant", name, items, err, func(item *tenants.Tenant) string {
+		return item.Name
+	})
+
+	return &TenantAssertion{SpaceAssertion: s, tenant: tenant}
+}
+
+// WithDescription asserts the description of the tenant
+func (a *TenantAssertion) WithDescription(description string) *TenantAssertion {
+	a.t.Helper()
+
+	if a.tenant != nil {
+		a.expectField(describe("tenant", a.tenant.Name), "description", description, a.tenant.Description)
+	}
+
+	return a
+}
+
+// WithTenantTags asserts the canonical names of the tags assigned to the tenant, such as "Region/Europe", in any order
+func (a *TenantAssertion) WithTenantTags(tags ...string) *TenantAssertion {
+	a.t.Helper()
+
+	if a.tenant != nil {
+		expected := append([]string{}, tags...)
+		actual := append([]string{}, a.tenant.TenantTags...)
+		slices.Sort(expected)
+		slices.Sort(actual)
+
+		a.expectField(describe("tenant", a.tenant.Name), "tenant tags", expected, actual)
+	}
+
+	return a
+}
@@ -0,0 +1,85 @@
+package assertions
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/OctopusDeploy/go-octopusdeploy/v2/pkg/client"
+)
+
+/*
+	This file contains fluent assertions for the resources in an Octopus space. Each assertion reads the resource
+	from the API and reports every mismatch with t.Errorf, showing the expected and actual values, so a single test
+	run lists all the differences. Assertions chained after a resource that was not found are skipped.
+*/
+
+// SpaceAssertion checks the resources in a space
+type SpaceAssertion struct {
+	t       testing.TB
+	client  *client.Client
+	spaceId string
+}
+
+// AssertSpace starts a chain of assertions against the resources in a space, for example:
+//
+//	assertions.AssertSpace(t, client, spaceId).
+//		HasEnvironment("Development").WithGuidedFailure(false).
+//		HasProject("My Project").WithLifecycle("Default Lifecycle").
+//		HasVariable("Database").WithValue("db.example.org")
+func AssertSpace(t testing.TB, client *client.Client, spaceId string) *SpaceAssertion {
+	return &SpaceAssertion{t: t, client: client, spaceId: spaceId}
+}
+
+// findByName returns the item with the matching name, or reports the names that were found in the owner, such as
+// the space or a project, and returns the zero value
+func findByName[T any](s *SpaceAssertion, owner string, kind string, name string, items []T, err error, nameOf func(T) string) T {
+	s.t.Helper()
+
+	var result T
+
+	if err != nil {
+		s.t.Errorf("failed to read the %ss in %s: %v", kind, owner, err)
+		return result
+	}
+
+	names := []string{}
+	for _, item := range items {
+		if nameOf(item) == name {
+			return item
+		}
+		names = append(names, fmt.Sprintf("%q", nameOf(item)))
+	}
+	sort.Strings(names)
+
+	found := "(none)"
+	if len(names) != 0 {
+		found = strings.Join(names, ", ")
+	}
+
+	s.t.Errorf("%s has no %s named %q\n  expected: %q\n  found:    %s", owner, kind, name, name, found)
+	return result
+}
+
+// label returns the description of the space used in failure messages
+func (s *SpaceAssertion) label() string {
+	return "space " + s.spaceId
+}
+
+// expectField reports a field whose actual value differs from the expected value
+func (s *SpaceAssertion) expectField(resource string, field string, expected any, actual any) {
+	s.t.Helper()
+
+	if reflect.DeepEqual(expected, actual) {
+		return
+	}
+
+	s.t.Errorf("%s has an unexpected %s\n  expected: %#v\n  actual:   %#v", resource, field, expected, actual)
+}
+
+// describe returns the label used for a resource in failure messages
+func describe(kind string, name string) string {
+	return kind + " " + fmt.Sprintf("%q", name)
+}
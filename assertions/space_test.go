@@ -0,0 +1,135 @@
+package assertions
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/OctopusDeploy/go-octopusdeploy/v2/pkg/feeds"
+	"github.com/OctopusDeploy/go-octopusdeploy/v2/pkg/variables"
+	"github.com/OctopusSolutionsEngineering/OctopusTerraformTestFramework/v2/octoclient"
+	"github.com/OctopusSolutionsEngineering/OctopusTerraformTestFramework/v2/octofake"
+)
+
+const testApiKey = "API-ABCDEFGHIJKLMNOPQURTUVWXYZ12345"
+
+// recordingT captures the failures reported by assertions, so tests can check assertions that are expected to fail
+type recordingT struct {
+	testing.TB
+	failures []string
+}
+
+func (r *recordingT) Errorf(format string, args ...any) {
+	r.failures = append(r.failures, fmt.Sprintf(format, args...))
+}
+
+func TestSpaceAssertions(t *testing.T) {
+	server := octofake.NewServer(testApiKey)
+	defer server.Close()
+
+	spaceId := octofake.DefaultSpaceId
+	lifecycleId := server.Resources(spaceId, "lifecycles")[0]["Id"]
+	projectGroupId := server.Resources(spaceId, "projectgroups")[0]["Id"]
+
+	server.AddResource(spaceId, "environments", map[string]any{"Name": "Development", "Description": "Dev", "UseGuidedFailure": false, "AllowDynamicInfrastructure": true})
+	server.AddResource(spaceId, "environments", map[string]any{"Name": "Test", "UseGuidedFailure": true})
+	project := server.AddResource(spaceId, "projects", map[string]any{"Name": "My Project", "LifecycleId": lifecycleId, "ProjectGroupId": projectGroupId})
+
+	testClient, err := octoclient.CreateClient(server.GetURI(), spaceId, testApiKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	variable := variables.NewVariable("Database")
+	variable.Value = "db.example.org"
+	variable.Type = "String"
+	if _, err := variables.AddSingle(testClient, spaceId, project["Id"].(string), variable); err != nil {
+		t.Fatal(err)
+	}
+
+	passing := &recordingT{TB: t}
+	AssertSpace(passing, testClient, spaceId).
+		HasEnvironment("Development").WithDescription("Dev").WithGuidedFailure(false).WithDynamicInfrastructure(true).
+		HasEnvironment("Test").WithGuidedFailure(true).
+		HasProject("My Project").WithLifecycle("Default Lifecycle").InProjectGroup("Default Project Group").
+		HasVariable("Database").WithValue("db.example.org").WithType("String").WithSensitive(false).
+		HasFeed("Octopus Server (built-in)").WithFeedType(feeds.FeedTypeBuiltIn).
+		HasLifecycle("Default Lifecycle")
+
+	if len(passing.failures) != 0 {
+		t.Errorf("Expected the assertions to pass, found:\n%s", strings.Join(passing.failures, "\n"))
+	}
+
+	failing := &recordingT{TB: t}
+	AssertSpace(failing, testClient, spaceId).
+		HasEnvironment("Production").WithGuidedFailure(true).
+		HasEnvironment("Development").WithGuidedFailure(true).
+		HasProject("My Project").HasVariable("Database").WithValue("localhost")
+
+	expectedFailures := []string{
+		"space Spaces-1 has no environment named \"Production\"\n  expected: \"Production\"\n  found:    \"Development\", \"Test\"",
+		"environment \"Development\" has an unexpected UseGuidedFailure\n  expected: true\n  actual:   false",
+		"variable \"Database\" in project \"My Project\" has an unexpected value\n  expected: \"localhost\"\n  actual:   \"db.example.org\"",
+	}
+
+	if !slices.Equal(failing.failures, expectedFailures) {
+		t.Errorf("Expected the failures:\n%s\nfound:\n%s", strings.Join(expectedFailures, "\n"), strings.Join(failing.failures, "\n"))
+	}
+}
+
+func TestMissingResourcesReportAnEmptyOwner(t *testing.T) {
+	server := octofake.NewServer(testApiKey)
+	defer server.Close()
+
+	testClient, err := octoclient.CreateClient(server.GetURI(), octofake.DefaultSpaceId, testApiKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recording := &recordingT{TB: t}
+	AssertSpace(recording, testClient, octofake.DefaultSpaceId).
+		HasTenant("Acme").WithDescription("Skipped as the tenant was not found")
+
+	expectedFailures := []string{
+		"space Spaces-1 has no tenant named \"Acme\"\n  expected: \"Acme\"\n  found:    (none)",
+	}
+
+	if !slices.Equal(recording.failures, expectedFailures) {
+		t.Errorf("Expected the failures:\n%s\nfound:\n%s", strings.Join(expectedFailures, "\n"), strings.Join(recording.failures, "\n"))
+	}
+}
+
+func TestUnreadableResourcesAreReported(t *testing.T) {
+	server := octofake.NewServer(testApiKey)
+
+	testClient, err := octoclient.CreateClient(server.GetURI(), octofake.DefaultSpaceId, testApiKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server.Close()
+
+	recording := &recordingT{TB: t}
+	AssertSpace(recording, testClient, octofake.DefaultSpaceId).HasEnvironment("Development")
+
+	if len(recording.failures) != 1 || !strings.HasPrefix(recording.failures[0], "failed to read the environments in space Spaces-1: ") {
+		t.Errorf("Expected the failure to read the environments to be reported, found %v", recording.failures)
+	}
+}
+
+func TestFieldMismatchesShowTheExpectedAndActualValues(t *testing.T) {
+	recording := &recordingT{TB: t}
+	assertion := AssertSpace(recording, nil, octofake.DefaultSpaceId)
+
+	assertion.expectField(describe("lifecycle", "Default Lifecycle"), "phases", []string{"Development", "Production"}, []string{"Development"})
+	assertion.expectField(describe("lifecycle", "Default Lifecycle"), "description", "", "")
+
+	expectedFailures := []string{
+		"lifecycle \"Default Lifecycle\" has an unexpected phases\n  expected: []string{\"Development\", \"Production\"}\n  actual:   []string{\"Development\"}",
+	}
+
+	if !slices.Equal(recording.failures, expectedFailures) {
+		t.Errorf("Expected the failures:\n%s\nfound:\n%s", strings.Join(expectedFailures, "\n"), strings.Join(recording.failures, "\n"))
+	}
+}
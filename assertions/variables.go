@@ -0,0 +1,208 @@
+package assertions
+
+import (
+	"github.com/OctopusDeploy/go-octopusdeploy/v2/pkg/libraryvariablesets"
+	"github.com/OctopusDeploy/go-octopusdeploy/v2/pkg/lifecycles"
+	"github.com/OctopusDeploy/go-octopusdeploy/v2/pkg/projectgroups"
+	"github.com/OctopusDeploy/go-octopusdeploy/v2/pkg/projects"
+	"github.com/OctopusDeploy/go-octopusdeploy/v2/pkg/variables"
+)
+
+// maxLibraryVariableSets is the number of library variable sets read when searching for a set by name
+const maxLibraryVariableSets = 1000
+
+// ProjectAssertion checks a project. The space assertions can be chained after it.
+type ProjectAssertion struct {
+	*SpaceAssertion
+	project *projects.Project
+}
+
+// HasProject asserts the space has a project with the supplied name
+func (s *SpaceAssertion) HasProject(name string) *ProjectAssertion {
+	s.t.Helper()
+
+	items, err := projects.GetAll(s.client, s.spaceId)
+	project := findByName(s, s.label(), "project", name, items, err, func(item *projects.Project) string {
+		return item.Name
+	})
+
+	return &ProjectAssertion{SpaceAssertion: s, project: project}
+}
+
+// WithDescription asserts the description of the project
+func (a *ProjectAssertion) WithDescription(description string) *ProjectAssertion {
+	a.t.Helper()
+
+	if a.project != nil {
+		a.expectField(describe("project", a.project.Name), "description", description, a.project.Description)
+	}
+
+	return a
+}
+
+// WithDisabled asserts whether the project is disabled
+func (a *ProjectAssertion) WithDisabled(isDisabled bool) *ProjectAssertion {
+	a.t.Helper()
+
+	if a.project != nil {
+		a.expectField(describe("project", a.project.Name), "IsDisabled", isDisabled, a.project.IsDisabled)
+	}
+
+	return a
+}
+
+// WithLifecycle asserts the name of the lifecycle used by the project
+func (a *ProjectAssertion) WithLifecycle(name string) *ProjectAssertion {
+	a.t.Helper()
+
+	if a.project == nil {
+		return a
+	}
+
+	lifecycle, err := lifecycles.GetByID(a.client, a.spaceId, a.project.LifecycleID)
+	if err != nil {
+		a.t.Errorf("failed to read the lifecycle %s of %s: %v", a.project.LifecycleID, describe("project", a.project.Name), err)
+		return a
+	}
+
+	a.expectField(describe("project", a.project.Name), "lifecycle", name, lifecycle.Name)
+	return a
+}
+
+// InProjectGroup asserts the name of the project group holding the project
+func (a *ProjectAssertion) InProjectGroup(name string) *ProjectAssertion {
+	a.t.Helper()
+
+	if a.project == nil {
+		return a
+	}
+
+	projectGroup, err := projectgroups.GetByID(a.client, a.spaceId, a.project.ProjectGroupID)
+	if err != nil {
+		a.t.Errorf("failed to read the project group %s of %s: %v", a.project.ProjectGroupID, describe("project", a.project.Name), err)
+		return a
+	}
+
+	a.expectField(describe("project", a.project.Name), "project group", name, projectGroup.Name)
+	return a
+}
+
+// HasVariable asserts the project defines a variable with the supplied name
+func (a *ProjectAssertion) HasVariable(name string) *VariableAssertion {
+	a.t.Helper()
+
+	if a.project == nil {
+		return &VariableAssertion{SpaceAssertion: a.SpaceAssertion}
+	}
+
+	return a.hasVariable(describe("project", a.project.Name), a.project.ID, name)
+}
+
+// LibraryVariableSetAssertion checks a library variable set. The space assertions can be chained after it.
+type LibraryVariableSetAssertion struct {
+	*SpaceAssertion
+	libraryVariableSet *variables.LibraryVariableSet
+}
+
+// HasLibraryVariableSet asserts the space has a library variable set with the supplied name
+func (s *SpaceAssertion) HasLibraryVariableSet(name string) *LibraryVariableSetAssertion {
+	s.t.Helper()
+
+	var items []*variables.LibraryVariableSet
+	result, err := libraryvariablesets.Get(s.client, s.spaceId, variables.LibraryVariablesQuery{PartialName: name, Take: maxLibraryVariableSets})
+	if err == nil {
+		items = result.Items
+	}
+
+	libraryVariableSet := findByName(s, s.label(), "library variable set", name, items, err, func(item *variables.LibraryVariableSet) string {
+		return item.Name
+	})
+
+	return &LibraryVariableSetAssertion{SpaceAssertion: s, libraryVariableSet: libraryVariableSet}
+}
+
+// WithDescription asserts the description of the library variable set
+func (a *LibraryVariableSetAssertion) WithDescription(description string) *LibraryVariableSetAssertion {
+	a.t.Helper()
+
+	if a.libraryVariableSet != nil {
+		a.expectField(describe("library variable set", a.libraryVariableSet.Name), "description", description, a.libraryVariableSet.Description)
+	}
+
+	return a
+}
+
+// HasVariable asserts the library variable set defines a variable with the supplied name
+func (a *LibraryVariableSetAssertion) HasVariable(name string) *VariableAssertion {
+	a.t.Helper()
+
+	if a.libraryVariableSet == nil {
+		return &VariableAssertion{SpaceAssertion: a.SpaceAssertion}
+	}
+
+	return a.hasVariable(describe("library variable set", a.libraryVariableSet.Name), a.libraryVariableSet.ID, name)
+}
+
+// VariableAssertion checks a variable. If several variables share the name, such as values scoped to different
+// environments, the first is checked. The space assertions can be chained after it.
+type VariableAssertion struct {
+	*SpaceAssertion
+	owner    string
+	variable *variables.Variable
+}
+
+// hasVariable finds a variable in the variable set of a project or library variable set
+func (s *SpaceAssertion) hasVariable(owner string, ownerId string, name string) *VariableAssertion {
+	s.t.Helper()
+
+	variableSet, err := variables.GetAll(s.client, s.spaceId, ownerId)
+	variable := findByName(s, owner, "variable", name, variableSet.Variables, err, func(item *variables.Variable) string {
+		return item.Name
+	})
+
+	return &VariableAssertion{SpaceAssertion: s, owner: owner, variable: variable}
+}
+
+// label returns the description of the variable used in failure messages
+func (a *VariableAssertion) label() string {
+	return describe("variable", a.variable.Name) + " in " + a.owner
+}
+
+// WithValue asserts the value of the variable. Sensitive values are not returned by the API, so can not be checked.
+func (a *VariableAssertion) WithValue(value string) *VariableAssertion {
+	a.t.Helper()
+
+	if a.variable == nil {
+		return a
+	}
+
+	if a.variable.IsSensitive {
+		a.t.Errorf("the value of %s can not be checked, as it is sensitive", a.label())
+		return a
+	}
+
+	a.expectField(a.label(), "value", value, a.variable.Value)
+	return a
+}
+
+// WithType asserts the type of the variable, such as "String" or "Sensitive"
+func (a *VariableAssertion) WithType(variableType string) *VariableAssertion {
+	a.t.Helper()
+
+	if a.variable != nil {
+		a.expectField(a.label(), "type", variableType, a.variable.Type)
+	}
+
+	return a
+}
+
+// WithSensitive asserts whether the variable is sensitive
+func (a *VariableAssertion) WithSensitive(isSensitive bool) *VariableAssertion {
+	a.t.Helper()
+
+	if a.variable != nil {
+		a.expectField(a.label(), "IsSensitive", isSensitive, a.variable.IsSensitive)
+	}
+
+	return a
+}
@@ -0,0 +1,612 @@
+package octofake
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/google/uuid"
+)
+
+/*
+	This file contains an in-process fake of the Octopus REST API. It implements enough of the API for the
+	go-octopusdeploy client and the octopusdeploy Terraform provider to create, read, update, and delete the
+	common space level resources, without a license, Docker, or a database. Resources are held in memory and
+	are not validated, so tests that depend on server side behaviour must still run against a real server.
+*/
+
+// collection describes an API collection supported by the fake server
+type collection struct {
+	// link is the name of the link to the collection in the API root document
+	link string
+	// idPrefix is the prefix of the IDs assigned to new resources
+	idPrefix string
+}
+
+// collections are the space level collections supported by the fake server
+var collections = map[string]collection{
+	"accounts":            {link: "Accounts", idPrefix: "Accounts"},
+	"certificates":        {link: "Certificates", idPrefix: "Certificates"},
+	"channels":            {link: "Channels", idPrefix: "Channels"},
+	"deploymentprocesses": {link: "DeploymentProcesses", idPrefix: "deploymentprocess"},
+	"environments":        {link: "Environments", idPrefix: "Environments"},
+	"feeds":               {link: "Feeds", idPrefix: "Feeds"},
+	"gitcredentials":      {link: "GitCredentials", idPrefix: "GitCredentials"},
+	"libraryvariablesets": {link: "LibraryVariables", idPrefix: "LibraryVariableSets"},
+	"lifecycles":          {link: "Lifecycles", idPrefix: "Lifecycles"},
+	"machinepolicies":     {link: "MachinePolicies", idPrefix: "MachinePolicies"},
+	"machines":            {link: "Machines", idPrefix: "Machines"},
+	"projectgroups":       {link: "ProjectGroups", idPrefix: "ProjectGroups"},
+	"projects":            {link: "Projects", idPrefix: "Projects"},
+	"projecttriggers":     {link: "ProjectTriggers", idPrefix: "ProjectTriggers"},
+	"runbookprocesses":    {link: "RunbookProcesses", idPrefix: "RunbookProcess"},
+	"runbooks":            {link: "Runbooks", idPrefix: "Runbooks"},
+	"tagsets":             {link: "TagSets", idPrefix: "TagSets"},
+	"tenants":             {link: "Tenants", idPrefix: "Tenants"},
+	"variables":           {link: "Variables", idPrefix: "variableset"},
+	"workerpools":         {link: "WorkerPools", idPrefix: "WorkerPools"},
+	"workers":             {link: "Workers", idPrefix: "Workers"},
+}
+
+// spacesCollection holds the spaces. Spaces are not scoped to a space, so they are stored with an empty space ID.
+const spacesCollection = "spaces"
+
+// DefaultSpaceId is the ID of the default space created with the server
+const DefaultSpaceId = "Spaces-1"
+
+// defaultPageSize is the number of items returned by a list request that does not define "take"
+const defaultPageSize = 30
+
+// Server is an in-memory fake of the Octopus REST API
+type Server struct {
+	*httptest.Server
+	apiKey    string
+	mutex     sync.Mutex
+	resources map[string]map[string][]map[string]any
+	nextIds   map[string]int
+	requests  []string
+}
+
+// NewServer starts a fake Octopus server that accepts the supplied API key. The server is created with a
+// default space, and must be closed with Close.
+func NewServer(apiKey string) *Server {
+	server := &Server{
+		apiKey:    apiKey,
+		resources: map[string]map[string][]map[string]any{},
+		nextIds:   map[string]int{},
+	}
+
+	server.createSpace(map[string]any{
+		"Name":               "Default",
+		"Description":        "",
+		"IsDefault":          true,
+		"TaskQueueStopped":   false,
+		"SpaceManagersTeams": []any{"teams-administrators"},
+	})
+
+	server.Server = httptest.NewServer(http.HandlerFunc(server.handle))
+
+	return server
+}
+
+// GetURI returns the base URL of the server
+func (s *Server) GetURI() string {
+	return s.URL
+}
+
+// GetApiKey returns the API key accepted by the server
+func (s *Server) GetApiKey() string {
+	return s.apiKey
+}
+
+// CreateSpace creates a new space, and returns the space ID
+func (s *Server) CreateSpace(ctx context.Context, name string) (string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	space := s.createSpace(map[string]any{
+		"Name":               name,
+		"Description":        "",
+		"IsDefault":          false,
+		"TaskQueueStopped":   false,
+		"SpaceManagersTeams": []any{"teams-administrators"},
+	})
+
+	return space["Id"].(string), nil
+}
+
+// DeleteSpace deletes a space and the resources it holds
+func (s *Server) DeleteSpace(ctx context.Context, spaceId string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if !s.delete("", spacesCollection, spaceId) {
+		return errors.New("the space " + spaceId + " was not found")
+	}
+
+	return nil
+}
+
+// GetLogs returns the requests handled by the server, one per line
+func (s *Server) GetLogs(ctx context.Context) (string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return strings.Join(s.requests, "\n"), nil
+}
+
+// WaitForReady returns immediately, as the server is ready as soon as it is created
+func (s *Server) WaitForReady(ctx context.Context) error {
+	return nil
+}
+
+// Shutdown closes the server
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.Close()
+	return nil
+}
+
+// Resources returns a copy of the resources held in a space level collection, such as "environments".
+// Use an empty space ID and the "spaces" collection to return the spaces.
+func (s *Server) Resources(spaceId string, collection string) []map[string]any {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	result := []map[string]any{}
+	for _, resource := range s.resources[spaceId][collection] {
+		result = append(result, copyResource(resource))
+	}
+
+	return result
+}
+
+// AddResource adds a resource to a space level collection, assigning an ID if the resource does not have one.
+// This is used to populate the server before a test.
+func (s *Server) AddResource(spaceId string, collection string, resource map[string]any) map[string]any {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return copyResource(s.create(spaceId, collection, copyResource(resource)))
+}
+
+// handle routes a request to the matching API endpoint
+func (s *Server) handle(w http.ResponseWriter, r *http.Request) {
+	segments := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+
+	if len(segments) == 0 || segments[0] != "api" {
+		writeError(w, http.StatusNotFound, "the path "+r.URL.Path+" was not found")
+		return
+	}
+	segments = segments[1:]
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.requests = append(s.requests, r.Method+" "+r.URL.RequestURI())
+
+	// The root documents are available to anonymous users
+	if len(segments) == 0 {
+		s.handleRoot(w, r, DefaultSpaceId)
+		return
+	}
+
+	if len(segments) == 1 && s.find("", spacesCollection, segments[0]) != nil {
+		s.handleRoot(w, r, segments[0])
+		return
+	}
+
+	if r.Header.Get("X-Octopus-ApiKey") != s.apiKey {
+		writeError(w, http.StatusUnauthorized, "you must be logged in to perform this action")
+		return
+	}
+
+	switch {
+	case segments[0] == "users" && len(segments) == 2 && segments[1] == "me":
+		writeJson(w, http.StatusOK, map[string]any{
+			"Id":           "Users-1",
+			"Username":     "admin",
+			"DisplayName":  "admin",
+			"IsActive":     true,
+			"IsService":    false,
+			"EmailAddress": "",
+		})
+	case segments[0] == spacesCollection:
+		s.handleCollection(w, r, "", spacesCollection, segments[1:])
+	case len(segments) >= 2 && s.find("", spacesCollection, segments[0]) != nil:
+		if _, ok := collections[segments[1]]; !ok {
+			s.notSupported(w, r)
+			return
+		}
+		s.handleCollection(w, r, segments[0], segments[1], segments[2:])
+	default:
+		s.notSupported(w, r)
+	}
+}
+
+// handleRoot returns the API root document, with links to the collections in the space
+func (s *Server) handleRoot(w http.ResponseWriter, r *http.Request, spaceId string) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "the method "+r.Method+" is not supported")
+		return
+	}
+
+	links := map[string]string{
+		"Self":        r.URL.Path,
+		"Spaces":      "/api/spaces{/id}{?skip,ids,take,partialName}",
+		"CurrentUser": "/api/users/me",
+	}
+
+	for name, collection := range collections {
+		links[collection.link] = "/api/" + spaceId + "/" + name + "{/id}{?name,skip,ids,take,partialName}"
+	}
+
+	writeJson(w, http.StatusOK, map[string]any{
+		"Application":        "Octopus Deploy",
+		"Version":            "2024.1.0",
+		"ApiVersion":         "3.0.0",
+		"InstallationId":     "00000000-0000-0000-0000-000000000000",
+		"HasLongTermSupport": false,
+		"Links":              links,
+	})
+}
+
+// handleCollection implements the create, read, update, and delete operations for a collection
+func (s *Server) handleCollection(w http.ResponseWriter, r *http.Request, spaceId string, collection string, segments []string) {
+	switch {
+	case len(segments) == 0 && r.Method == http.MethodGet:
+		s.list(w, r, spaceId, collection)
+	case len(segments) == 0 && r.Method == http.MethodPost:
+		resource, ok := readResource(w, r)
+		if !ok {
+			return
+		}
+		delete(resource, "Id")
+
+		if collection == spacesCollection {
+			resource = s.createSpace(resource)
+		} else {
+			resource = s.create(spaceId, collection, resource)
+		}
+
+		writeJson(w, http.StatusCreated, resource)
+	case len(segments) == 1 && segments[0] == "all" && r.Method == http.MethodGet:
+		writeJson(w, http.StatusOK, append([]map[string]any{}, s.resources[spaceId][collection]...))
+	case len(segments) == 1 && r.Method == http.MethodGet:
+		if resource := s.find(spaceId, collection, segments[0]); resource != nil {
+			writeJson(w, http.StatusOK, resource)
+		} else {
+			writeError(w, http.StatusNotFound, "the resource "+segments[0]+" was not found")
+		}
+	case len(segments) == 1 && r.Method == http.MethodPut:
+		existing := s.find(spaceId, collection, segments[0])
+		if existing == nil {
+			writeError(w, http.StatusNotFound, "the resource "+segments[0]+" was not found")
+			return
+		}
+
+		resource, ok := readResource(w, r)
+		if !ok {
+			return
+		}
+
+		writeJson(w, http.StatusOK, s.update(existing, resource))
+	case len(segments) == 1 && r.Method == http.MethodDelete:
+		if !s.delete(spaceId, collection, segments[0]) {
+			writeError(w, http.StatusNotFound, "the resource "+segments[0]+" was not found")
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+	default:
+		s.notSupported(w, r)
+	}
+}
+
+// notSupported logs and rejects requests the fake server does not implement
+func (s *Server) notSupported(w http.ResponseWriter, r *http.Request) {
+	log.Printf("The fake Octopus server does not support %s %s", r.Method, r.URL.Path)
+	writeError(w, http.StatusNotFound, "the fake Octopus server does not support "+r.Method+" "+r.URL.Path)
+}
+
+// list returns a page of resources, filtered by the ids, name, and partialName query parameters
+func (s *Server) list(w http.ResponseWriter, r *http.Request, spaceId string, collection string) {
+	query := r.URL.Query()
+
+	ids := []string{}
+	for _, id := range query["ids"] {
+		ids = append(ids, strings.Split(id, ",")...)
+	}
+	name := strings.ToLower(query.Get("name"))
+	partialName := strings.ToLower(query.Get("partialName"))
+
+	items := []map[string]any{}
+	for _, resource := range s.resources[spaceId][collection] {
+		id, _ := resource["Id"].(string)
+		resourceName, _ := resource["Name"].(string)
+		resourceName = strings.ToLower(resourceName)
+
+		if len(ids) != 0 && !slices.Contains(ids, id) {
+			continue
+		}
+
+		if name != "" && resourceName != name {
+			continue
+		}
+
+		if partialName != "" && !strings.Contains(resourceName, partialName) {
+			continue
+		}
+
+		items = append(items, resource)
+	}
+
+	skip := queryInt(query, "skip", 0)
+	take := queryInt(query, "take", defaultPageSize)
+	total := len(items)
+
+	links := map[string]string{"Self": r.URL.RequestURI()}
+
+	page := items[min(skip, total):min(skip+take, total)]
+	if skip+take < total {
+		next := url.Values{}
+		for key, value := range query {
+			next[key] = value
+		}
+		next.Set("skip", strconv.Itoa(skip+take))
+		next.Set("take", strconv.Itoa(take))
+		links["Page.Next"] = r.URL.Path + "?" + next.Encode()
+	}
+
+	pages := 0
+	if take > 0 {
+		pages = (total + take - 1) / take
+	}
+
+	writeJson(w, http.StatusOK, map[string]any{
+		"ItemType":       collection,
+		"TotalResults":   total,
+		"ItemsPerPage":   take,
+		"NumberOfPages":  pages,
+		"LastPageNumber": max(pages-1, 0),
+		"Items":          page,
+		"Links":          links,
+	})
+}
+
+// find returns the resource with the matching ID, or nil if it does not exist
+func (s *Server) find(spaceId string, collection string, id string) map[string]any {
+	for _, resource := range s.resources[spaceId][collection] {
+		if resource["Id"] == id {
+			return resource
+		}
+	}
+
+	return nil
+}
+
+// nextId returns a new ID with the supplied prefix. IDs are unique across spaces, like a real server.
+func (s *Server) nextId(prefix string) string {
+	s.nextIds[prefix]++
+	return prefix + "-" + strconv.Itoa(s.nextIds[prefix])
+}
+
+// create stores a new resource, and creates any resources a real server creates alongside it
+func (s *Server) create(spaceId string, collection string, resource map[string]any) map[string]any {
+	id, _ := resource["Id"].(string)
+	if id == "" {
+		prefix := collections[collection].idPrefix
+		if collection == spacesCollection {
+			prefix = "Spaces"
+		}
+		id = s.nextId(prefix)
+		resource["Id"] = id
+	}
+
+	if spaceId != "" {
+		resource["SpaceId"] = spaceId
+	}
+	resource["Links"] = map[string]any{"Self": "/api/" + spaceId + "/" + collection + "/" + id}
+	assignMissingIds(resource)
+
+	if s.resources[spaceId] == nil {
+		s.resources[spaceId] = map[string][]map[string]any{}
+	}
+	s.resources[spaceId][collection] = append(s.resources[spaceId][collection], resource)
+
+	switch collection {
+	case "projects":
+		resource["VariableSetId"] = s.createVariableSet(spaceId, id)["Id"]
+		resource["DeploymentProcessId"] = s.create(spaceId, "deploymentprocesses", map[string]any{
+			"Id":        "deploymentprocess-" + id,
+			"ProjectId": id,
+			"Steps":     []any{},
+			"Version":   0,
+		})["Id"]
+	case "libraryvariablesets":
+		resource["VariableSetId"] = s.createVariableSet(spaceId, id)["Id"]
+	case "runbooks":
+		resource["RunbookProcessId"] = s.create(spaceId, "runbookprocesses", map[string]any{
+			"Id":        "RunbookProcess-" + id,
+			"RunbookId": id,
+			"ProjectId": resource["ProjectId"],
+			"Steps":     []any{},
+			"Version":   0,
+		})["Id"]
+	}
+
+	return resource
+}
+
+// createVariableSet creates the variable set owned by a project or library variable set
+func (s *Server) createVariableSet(spaceId string, ownerId string) map[string]any {
+	return s.create(spaceId, "variables", map[string]any{
+		"Id":          "variableset-" + ownerId,
+		"OwnerId":     ownerId,
+		"Version":     0,
+		"Variables":   []any{},
+		"ScopeValues": map[string]any{},
+	})
+}
+
+// createSpace stores a new space along with the resources Octopus creates in every new space
+func (s *Server) createSpace(space map[string]any) map[string]any {
+	space = s.create("", spacesCollection, space)
+	spaceId := space["Id"].(string)
+	space["Links"] = map[string]any{"Self": "/api/spaces/" + spaceId, "SpaceHome": "/api/" + spaceId}
+
+	s.create(spaceId, "projectgroups", map[string]any{"Name": "Default Project Group", "Description": ""})
+	s.create(spaceId, "lifecycles", map[string]any{"Name": "Default Lifecycle", "Description": "", "Phases": []any{}})
+	s.create(spaceId, "machinepolicies", map[string]any{"Name": "Default Machine Policy", "IsDefault": true})
+	s.create(spaceId, "workerpools", map[string]any{"Name": "Default Worker Pool", "IsDefault": true, "WorkerPoolType": "StaticWorkerPool"})
+	s.create(spaceId, "feeds", map[string]any{"Name": "Octopus Server (built-in)", "FeedType": "BuiltIn"})
+
+	return space
+}
+
+// update replaces the fields of an existing resource, retaining the fields assigned by the server
+func (s *Server) update(existing map[string]any, resource map[string]any) map[string]any {
+	preserved := map[string]any{}
+	for _, field := range []string{"Id", "SpaceId", "Links", "VariableSetId", "DeploymentProcessId", "RunbookProcessId"} {
+		if value, ok := existing[field]; ok {
+			preserved[field] = value
+		}
+	}
+
+	version, hasVersion := existing["Version"].(float64)
+	if versionInt, ok := existing["Version"].(int); ok {
+		version, hasVersion = float64(versionInt), true
+	}
+
+	clear(existing)
+	for key, value := range resource {
+		existing[key] = value
+	}
+	for key, value := range preserved {
+		existing[key] = value
+	}
+
+	if hasVersion {
+		existing["Version"] = version + 1
+	}
+
+	assignMissingIds(existing)
+
+	return existing
+}
+
+// delete removes a resource, along with the resources it owns. The return value is false if the resource does not exist.
+func (s *Server) delete(spaceId string, collection string, id string) bool {
+	resources := s.resources[spaceId][collection]
+	index := slices.IndexFunc(resources, func(resource map[string]any) bool {
+		return resource["Id"] == id
+	})
+
+	if index == -1 {
+		return false
+	}
+
+	resource := resources[index]
+	s.resources[spaceId][collection] = slices.Delete(resources, index, index+1)
+
+	switch collection {
+	case spacesCollection:
+		delete(s.resources, id)
+	case "projects", "libraryvariablesets", "runbooks":
+		for _, owned := range []string{"VariableSetId", "DeploymentProcessId"} {
+			if ownedId, ok := resource[owned].(string); ok {
+				s.delete(spaceId, "variables", ownedId)
+				s.delete(spaceId, "deploymentprocesses", ownedId)
+			}
+		}
+		if ownedId, ok := resource["RunbookProcessId"].(string); ok {
+			s.delete(spaceId, "runbookprocesses", ownedId)
+		}
+	}
+
+	return true
+}
+
+// assignMissingIds assigns IDs to the nested variables, steps, and actions that were created by the client
+func assignMissingIds(resource map[string]any) {
+	for _, field := range []string{"Variables", "Steps", "Actions"} {
+		items, ok := resource[field].([]any)
+		if !ok {
+			continue
+		}
+
+		for _, item := range items {
+			child, ok := item.(map[string]any)
+			if !ok {
+				continue
+			}
+
+			if id, _ := child["Id"].(string); id == "" {
+				child["Id"] = uuid.New().String()
+			}
+
+			assignMissingIds(child)
+		}
+	}
+}
+
+// copyResource returns a deep copy of a resource, so callers can not modify the state of the server
+func copyResource(resource map[string]any) map[string]any {
+	body, err := json.Marshal(resource)
+	if err != nil {
+		panic(err)
+	}
+
+	result := map[string]any{}
+	if err := json.Unmarshal(body, &result); err != nil {
+		panic(err)
+	}
+
+	return result
+}
+
+// readResource decodes the request body, writing an error response if the body is not a JSON object
+func readResource(w http.ResponseWriter, r *http.Request) (map[string]any, bool) {
+	resource := map[string]any{}
+	if err := json.NewDecoder(r.Body).Decode(&resource); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("the request body could not be parsed: %v", err))
+		return nil, false
+	}
+
+	return resource, true
+}
+
+// queryInt returns an integer query parameter, or the default value if the parameter is missing or invalid
+func queryInt(query url.Values, name string, defaultValue int) int {
+	value, err := strconv.Atoi(query.Get(name))
+	if err != nil || value < 0 {
+		return defaultValue
+	}
+
+	return value
+}
+
+// writeJson writes a JSON response
+func writeJson(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		log.Println("Failed to write the response: " + err.Error())
+	}
+}
+
+// writeError writes an error response in the format returned by Octopus
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJson(w, status, map[string]any{
+		"ErrorMessage": message,
+		"Errors":       []string{message},
+	})
+}
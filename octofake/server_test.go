@@ -0,0 +1,86 @@
+package octofake
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/OctopusDeploy/go-octopusdeploy/v2/pkg/environments"
+	"github.com/OctopusSolutionsEngineering/OctopusTerraformTestFramework/v2/octoclient"
+)
+
+const testApiKey = "API-ABCDEFGHIJKLMNOPQURTUVWXYZ12345"
+
+// TestCreatesEnvironments runs the same client calls as the TestCreateEnvironments integration test
+func TestCreatesEnvironments(t *testing.T) {
+	server := NewServer(testApiKey)
+	defer server.Close()
+
+	testClient, err := octoclient.CreateClient(server.GetURI(), "", testApiKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	created, err := environments.Add(testClient, environments.NewEnvironment("Development"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	found, err := environments.GetByID(testClient, testClient.GetSpaceID(), created.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if found.Name != "Development" {
+		t.Errorf("Expected the environment name Development, found %s", found.Name)
+	}
+
+	all, err := environments.GetAll(testClient, testClient.GetSpaceID())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(all) != 1 {
+		t.Errorf("Expected 1 environment, found %d", len(all))
+	}
+
+	if err := environments.DeleteByID(testClient, testClient.GetSpaceID(), created.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	if resources := server.Resources(DefaultSpaceId, "environments"); len(resources) != 0 {
+		t.Errorf("Expected the environment to be deleted, found %v", resources)
+	}
+}
+
+func TestRejectsInvalidApiKeys(t *testing.T) {
+	server := NewServer(testApiKey)
+	defer server.Close()
+
+	if _, err := octoclient.CreateClient(server.GetURI(), "", "API-INVALID"); err == nil {
+		t.Errorf("Expected the client to be rejected")
+	}
+}
+
+func TestPagesResults(t *testing.T) {
+	server := NewServer(testApiKey)
+	defer server.Close()
+
+	// More environments than fit in the default page size
+	for i := 0; i < 35; i++ {
+		server.AddResource(DefaultSpaceId, "environments", map[string]any{"Name": fmt.Sprintf("Environment %d", i)})
+	}
+
+	testClient, err := octoclient.CreateClient(server.GetURI(), "", testApiKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	all, err := environments.GetAll(testClient, DefaultSpaceId)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(all) != 35 {
+		t.Errorf("Expected 35 environments, found %d", len(all))
+	}
+}
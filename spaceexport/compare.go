@@ -0,0 +1,171 @@
+package spaceexport
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"sort"
+)
+
+/*
+	This file contains the structural comparison of two normalised exports. Resources are matched by name rather than
+	by position, so the comparison reports the resources that are missing or unexpected, and the individual fields that
+	differ, rather than a line diff.
+*/
+
+// missingValue is reported in place of a field that is not present
+const missingValue = "<missing>"
+
+// Compare returns a description of every difference between two normalised exports, such as a resource that is
+// missing from the actual space or a field with a different value. Fields with a name in ignoreFields are not compared
+// at any depth. An empty result means the exports are equivalent.
+func Compare(expected Space, actual Space, ignoreFields ...string) []string {
+	differences := []string{}
+	for _, collection := range sortedKeys(expected, actual) {
+		expectedResources := keyResources(expected[collection])
+		actualResources := keyResources(actual[collection])
+
+		for _, key := range sortedKeys(expectedResources, actualResources) {
+			label := collection + "/" + key
+			expectedResource, inExpected := expectedResources[key]
+			actualResource, inActual := actualResources[key]
+
+			switch {
+			case !inActual:
+				differences = append(differences, label+" is missing")
+			case !inExpected:
+				differences = append(differences, label+" was not expected")
+			default:
+				differences = compareValues(differences, label, "", expectedResource, actualResource, ignoreFields)
+			}
+		}
+	}
+
+	return differences
+}
+
+// keyResources maps each resource to a key identifying it in both exports. Named resources are keyed by their name,
+// prefixed with their project if they are scoped to one. Unnamed resources, such as variable sets, are keyed by the
+// reference to their owner. Resources that share a key are numbered in the order they were sorted by Normalise.
+func keyResources(resources []map[string]any) map[string]map[string]any {
+	keyed := map[string]map[string]any{}
+	for _, resource := range resources {
+		key := resourceKey(resource)
+
+		unique := key
+		for i := 2; keyed[unique] != nil; i++ {
+			unique = fmt.Sprintf("%s#%d", key, i)
+		}
+
+		keyed[unique] = resource
+	}
+
+	return keyed
+}
+
+// resourceKey returns the key identifying a normalised resource
+func resourceKey(resource map[string]any) string {
+	name, _ := resource["Name"].(string)
+	projectId, _ := resource["ProjectId"].(string)
+
+	switch {
+	case name != "" && projectId != "":
+		return name + " in " + projectId
+	case name != "":
+		return name
+	}
+
+	for _, ownerField := range []string{"OwnerId", "RunbookId", "ProjectId"} {
+		if ownerId, _ := resource[ownerField].(string); ownerId != "" {
+			return ownerId
+		}
+	}
+
+	content, _ := json.Marshal(resource)
+	return string(content)
+}
+
+// compareValues appends the differences between two JSON values to the differences. Objects are compared field by
+// field and arrays item by item, so the path to each changed value is reported.
+func compareValues(differences []string, label string, path string, expected any, actual any, ignoreFields []string) []string {
+	expectedObject, expectedIsObject := expected.(map[string]any)
+	actualObject, actualIsObject := actual.(map[string]any)
+	if expectedIsObject && actualIsObject {
+		for _, field := range sortedKeys(expectedObject, actualObject) {
+			if slices.Contains(ignoreFields, field) {
+				continue
+			}
+
+			fieldPath := field
+			if path != "" {
+				fieldPath = path + "." + field
+			}
+
+			expectedValue, inExpected := expectedObject[field]
+			actualValue, inActual := actualObject[field]
+			switch {
+			case !inActual:
+				differences = append(differences, describeDifference(label, fieldPath, expectedValue, missingValue))
+			case !inExpected:
+				differences = append(differences, describeDifference(label, fieldPath, missingValue, actualValue))
+			default:
+				differences = compareValues(differences, label, fieldPath, expectedValue, actualValue, ignoreFields)
+			}
+		}
+
+		return differences
+	}
+
+	expectedArray, expectedIsArray := expected.([]any)
+	actualArray, actualIsArray := actual.([]any)
+	if expectedIsArray && actualIsArray && len(expectedArray) == len(actualArray) {
+		for i := range expectedArray {
+			differences = compareValues(differences, label, fmt.Sprintf("%s[%d]", path, i), expectedArray[i], actualArray[i], ignoreFields)
+		}
+
+		return differences
+	}
+
+	expectedContent, _ := json.Marshal(expected)
+	actualContent, _ := json.Marshal(actual)
+	if string(expectedContent) == string(actualContent) {
+		return differences
+	}
+
+	return append(differences, describeDifference(label, path, expected, actual))
+}
+
+// describeDifference returns the description of a field with a different value
+func describeDifference(label string, path string, expected any, actual any) string {
+	return fmt.Sprintf("%s has a different %s\n  expected: %s\n  actual:   %s", label, path, formatValue(expected), formatValue(actual))
+}
+
+// formatValue returns a value as JSON, or the missing value marker unquoted
+func formatValue(value any) string {
+	if value == missingValue {
+		return missingValue
+	}
+
+	content, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprint(value)
+	}
+
+	return string(content)
+}
+
+// sortedKeys returns the keys of both maps, sorted
+func sortedKeys[T any](expected map[string]T, actual map[string]T) []string {
+	keys := []string{}
+	for key := range expected {
+		keys = append(keys, key)
+	}
+	for key := range actual {
+		if _, ok := expected[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	return keys
+}
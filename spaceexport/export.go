@@ -0,0 +1,111 @@
+package spaceexport
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/OctopusDeploy/go-octopusdeploy/v2/pkg/client"
+	"github.com/OctopusDeploy/go-octopusdeploy/v2/pkg/core"
+	"github.com/OctopusDeploy/go-octopusdeploy/v2/pkg/newclient"
+)
+
+/*
+	This file contains the exporter that reads every resource in a space. Resources are read as JSON documents rather
+	than the typed structs of go-octopusdeploy, so fields added by newer versions of Octopus are included in the export.
+*/
+
+// Collections are the API collections that hold the resources in a space
+var Collections = []string{
+	"accounts",
+	"certificates",
+	"channels",
+	"environments",
+	"feeds",
+	"gitcredentials",
+	"libraryvariablesets",
+	"lifecycles",
+	"machinepolicies",
+	"machines",
+	"projectgroups",
+	"projects",
+	"projecttriggers",
+	"runbooks",
+	"tagsets",
+	"tenants",
+	"workerpools",
+	"workers",
+}
+
+// ownedResources maps the fields of a resource that hold the ID of a document it owns to the collection of the document.
+// These documents can not be listed, so they are read through their owner.
+var ownedResources = map[string]string{
+	"VariableSetId":       "variables",
+	"DeploymentProcessId": "deploymentprocesses",
+	"RunbookProcessId":    "runbookprocesses",
+}
+
+// pageSize is the number of resources read in each request
+const pageSize = 1000
+
+// Space maps an API collection, such as "environments", to the resources it holds
+type Space map[string][]map[string]any
+
+// Export reads every resource in the space, including the variable sets and processes owned by projects, library
+// variable sets, and runbooks. Collections not supported by the server are skipped.
+func Export(client *client.Client, spaceId string) (Space, error) {
+	space := Space{}
+
+	for _, collection := range Collections {
+		path := "/api/" + spaceId + "/" + collection + fmt.Sprintf("?skip=0&take=%d", pageSize)
+
+		for path != "" {
+			page, err := newclient.Get[struct {
+				Items []map[string]any
+				Links map[string]string
+			}](client.HttpSession(), path)
+
+			// Older versions of Octopus do not support every collection
+			if isNotFound(err) {
+				break
+			}
+
+			if err != nil {
+				return nil, fmt.Errorf("failed to read the %s collection: %w", collection, err)
+			}
+
+			space[collection] = append(space[collection], page.Items...)
+			path = page.Links["Page.Next"]
+		}
+	}
+
+	for _, collection := range Collections {
+		for _, resource := range space[collection] {
+			for field, ownedCollection := range ownedResources {
+				ownedId, _ := resource[field].(string)
+				if ownedId == "" {
+					continue
+				}
+
+				owned, err := newclient.Get[map[string]any](client.HttpSession(), "/api/"+spaceId+"/"+ownedCollection+"/"+ownedId)
+				if isNotFound(err) {
+					continue
+				}
+
+				if err != nil {
+					return nil, fmt.Errorf("failed to read %s: %w", ownedId, err)
+				}
+
+				space[ownedCollection] = append(space[ownedCollection], *owned)
+			}
+		}
+	}
+
+	return space, nil
+}
+
+// isNotFound returns true if the error is a 404 response
+func isNotFound(err error) bool {
+	var apiError *core.APIError
+	return errors.As(err, &apiError) && apiError.StatusCode == http.StatusNotFound
+}
@@ -0,0 +1,221 @@
+package spaceexport
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/OctopusDeploy/go-octopusdeploy/v2/pkg/client"
+)
+
+/*
+	This file contains the golden file comparison. A golden file is the normalised export of a space that a test
+	expects, checked in alongside the test. Running the tests with OCTOTESTUPDATEGOLDEN=true, or with the -update flag
+	once a test package has called RegisterUpdateFlag, rewrites the golden files from the spaces the tests created.
+*/
+
+// updateEnvironmentVariable is the environment variable that rewrites the golden files
+const updateEnvironmentVariable = "OCTOTESTUPDATEGOLDEN"
+
+// maxDiffLines is the number of lines of a diff included in a failure message
+const maxDiffLines = 100
+
+// maxDiffCells is the largest table used to find the lines that changed. Larger changes only show the first line that
+// is different, as the table holds one int for every pair of changed lines.
+const maxDiffCells = 1_000_000
+
+// updateGolden is set by SetUpdateGoldenFiles
+var updateGolden atomic.Bool
+
+// SetUpdateGoldenFiles makes AssertGolden write the golden files rather than compare them
+func SetUpdateGoldenFiles(update bool) {
+	updateGolden.Store(update)
+}
+
+// RegisterUpdateFlag defines the -update flag, so "go test -update" rewrites the golden files. This package does not
+// define the flag when it is imported, as the flag package panics if the test package, or another package it imports,
+// defines a flag with the same name. Call it from TestMain before the flags are parsed:
+//
+//	func TestMain(m *testing.M) {
+//		spaceexport.RegisterUpdateFlag()
+//		os.Exit(m.Run())
+//	}
+func RegisterUpdateFlag() {
+	flag.BoolFunc("update", "rewrite the golden files", func(value string) error {
+		update, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+
+		SetUpdateGoldenFiles(update)
+		return nil
+	})
+}
+
+// updateGoldenFiles returns true if the golden files are rewritten rather than compared. Defaults to the value passed
+// to SetUpdateGoldenFiles, and then to OCTOTESTUPDATEGOLDEN.
+func updateGoldenFiles() bool {
+	if updateGolden.Load() {
+		return true
+	}
+
+	return strings.ToLower(os.Getenv(updateEnvironmentVariable)) == "true"
+}
+
+// AssertGolden exports and normalises the space, and fails the test with a diff if the result does not match the
+// golden file. Call it once Act has populated the space:
+//
+//	spaceId, err := testFramework.Act(t, container, "../terraform", "2-simpleexample", []string{})
+//	spaceexport.AssertGolden(t, client, spaceId, "testdata/simpleexample.golden.json")
+//
+// Run the tests with OCTOTESTUPDATEGOLDEN=true, or with -update if RegisterUpdateFlag was called, to write the golden
+// file instead.
+func AssertGolden(t testing.TB, client *client.Client, spaceId string, goldenFile string) {
+	t.Helper()
+
+	space, err := Export(client, spaceId)
+	if err != nil {
+		t.Errorf("failed to export space %s: %v", spaceId, err)
+		return
+	}
+
+	content, err := Marshal(Normalise(space))
+	if err != nil {
+		t.Errorf("failed to serialise space %s: %v", spaceId, err)
+		return
+	}
+
+	AssertGoldenContent(t, content, goldenFile)
+}
+
+// AssertGoldenContent fails the test with a diff if the content does not match the golden file, or writes the content
+// to the golden file if the golden files are being updated
+func AssertGoldenContent(t testing.TB, content []byte, goldenFile string) {
+	t.Helper()
+
+	if updateGoldenFiles() {
+		if err := os.MkdirAll(filepath.Dir(goldenFile), 0o755); err != nil {
+			t.Errorf("failed to create the golden file directory: %v", err)
+			return
+		}
+
+		if err := os.WriteFile(goldenFile, content, 0o644); err != nil {
+			t.Errorf("failed to write the golden file: %v", err)
+			return
+		}
+
+		t.Logf("Updated the golden file %s", goldenFile)
+		return
+	}
+
+	expected, err := os.ReadFile(goldenFile)
+	if errors.Is(err, os.ErrNotExist) {
+		t.Errorf("the golden file %s does not exist. Run the tests with %s=true to create it.", goldenFile, updateEnvironmentVariable)
+		return
+	}
+
+	if err != nil {
+		t.Errorf("failed to read the golden file: %v", err)
+		return
+	}
+
+	if bytes.Equal(expected, content) {
+		return
+	}
+
+	t.Errorf("the space does not match the golden file %s. Run the tests with %s=true to accept the changes.\n%s",
+		goldenFile, updateEnvironmentVariable, diffLines(string(expected), string(content)))
+}
+
+// diffLines returns the lines removed from the expected text, prefixed with "-", and the lines added in the actual
+// text, prefixed with "+". Each change starts with a header holding its line number in the expected text.
+// The lines shared by the start and end of both texts are skipped before the changed lines are compared.
+func diffLines(expected string, actual string) string {
+	expectedLines := strings.Split(expected, "\n")
+	actualLines := strings.Split(actual, "\n")
+
+	prefix := 0
+	for prefix < len(expectedLines) && prefix < len(actualLines) && expectedLines[prefix] == actualLines[prefix] {
+		prefix++
+	}
+
+	suffix := 0
+	for suffix < len(expectedLines)-prefix && suffix < len(actualLines)-prefix &&
+		expectedLines[len(expectedLines)-1-suffix] == actualLines[len(actualLines)-1-suffix] {
+		suffix++
+	}
+
+	expectedLines = expectedLines[prefix : len(expectedLines)-suffix]
+	actualLines = actualLines[prefix : len(actualLines)-suffix]
+
+	if (len(expectedLines)+1)*(len(actualLines)+1) > maxDiffCells {
+		return firstDifference(prefix, expectedLines, actualLines)
+	}
+
+	// lengths[i][j] is the length of the longest common subsequence of expectedLines[i:] and actualLines[j:]
+	lengths := make([][]int, len(expectedLines)+1)
+	for i := range lengths {
+		lengths[i] = make([]int, len(actualLines)+1)
+	}
+	for i := len(expectedLines) - 1; i >= 0; i-- {
+		for j := len(actualLines) - 1; j >= 0; j-- {
+			if expectedLines[i] == actualLines[j] {
+				lengths[i][j] = lengths[i+1][j+1] + 1
+			} else {
+				lengths[i][j] = max(lengths[i+1][j], lengths[i][j+1])
+			}
+		}
+	}
+
+	diff := []string{}
+	inChange := false
+	i, j := 0, 0
+	for i < len(expectedLines) || j < len(actualLines) {
+		switch {
+		case i < len(expectedLines) && j < len(actualLines) && expectedLines[i] == actualLines[j]:
+			inChange = false
+			i++
+			j++
+			continue
+		case !inChange:
+			diff = append(diff, fmt.Sprintf("@@ line %d @@", prefix+i+1))
+			inChange = true
+		}
+
+		if j == len(actualLines) || (i < len(expectedLines) && lengths[i+1][j] >= lengths[i][j+1]) {
+			diff = append(diff, "-"+expectedLines[i])
+			i++
+		} else {
+			diff = append(diff, "+"+actualLines[j])
+			j++
+		}
+	}
+
+	if len(diff) > maxDiffLines {
+		diff = append(diff[:maxDiffLines], fmt.Sprintf("... %d more lines", len(diff)-maxDiffLines))
+	}
+
+	return strings.Join(diff, "\n")
+}
+
+// firstDifference returns the first line of the expected and actual text, following the common prefix, for changes
+// too large to compare line by line
+func firstDifference(prefix int, expectedLines []string, actualLines []string) string {
+	diff := []string{fmt.Sprintf("@@ line %d @@", prefix+1)}
+	if len(expectedLines) != 0 {
+		diff = append(diff, "-"+expectedLines[0])
+	}
+	if len(actualLines) != 0 {
+		diff = append(diff, "+"+actualLines[0])
+	}
+
+	return strings.Join(append(diff, fmt.Sprintf("... %d lines removed and %d lines added are too many to compare",
+		len(expectedLines), len(actualLines))), "\n")
+}
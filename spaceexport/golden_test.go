@@ -0,0 +1,175 @@
+package spaceexport
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/OctopusDeploy/go-octopusdeploy/v2/pkg/client"
+	"github.com/OctopusDeploy/go-octopusdeploy/v2/pkg/variables"
+	"github.com/OctopusSolutionsEngineering/OctopusTerraformTestFramework/v2/octoclient"
+	"github.com/OctopusSolutionsEngineering/OctopusTerraformTestFramework/v2/octofake"
+)
+
+const testApiKey = "API-ABCDEFGHIJKLMNOPQURTUVWXYZ12345"
+
+// recordingT captures the failures reported by the golden file assertions, so tests can check mismatches
+type recordingT struct {
+	testing.TB
+	failures []string
+}
+
+func (r *recordingT) Errorf(format string, args ...any) {
+	r.failures = append(r.failures, fmt.Sprintf(format, args...))
+}
+
+// populateFakeSpace adds the same resources to a fake server, creating the environments in the supplied order
+func populateFakeSpace(t *testing.T, server *octofake.Server, environmentNames []string) *client.Client {
+	spaceId := octofake.DefaultSpaceId
+	environmentIds := map[string]any{}
+	for _, name := range environmentNames {
+		environmentIds[name] = server.AddResource(spaceId, "environments", map[string]any{"Name": name, "LastModifiedOn": time.Now().Format(time.RFC3339)})["Id"]
+	}
+
+	project := server.AddResource(spaceId, "projects", map[string]any{
+		"Name":           "My Project",
+		"LifecycleId":    server.Resources(spaceId, "lifecycles")[0]["Id"],
+		"ProjectGroupId": server.Resources(spaceId, "projectgroups")[0]["Id"],
+	})
+
+	testClient, err := octoclient.CreateClient(server.GetURI(), spaceId, testApiKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"Database", "Url"} {
+		variable := variables.NewVariable(name)
+		variable.Value = strings.ToLower(name)
+		variable.Scope.Environments = []string{environmentIds["Test"].(string)}
+		if _, err := variables.AddSingle(testClient, spaceId, project["Id"].(string), variable); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return testClient
+}
+
+func TestSpaceExportsMatchTheGoldenFile(t *testing.T) {
+	t.Setenv("OCTOTESTUPDATEGOLDEN", "")
+
+	exports := [][]byte{}
+	for _, environmentNames := range [][]string{{"Development", "Test"}, {"Test", "Development"}} {
+		server := octofake.NewServer(testApiKey)
+		defer server.Close()
+
+		// The environments are created in a different order, so the second server assigns them different IDs
+		testClient := populateFakeSpace(t, server, environmentNames)
+
+		space, err := Export(testClient, octofake.DefaultSpaceId)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		content, err := Marshal(Normalise(space))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		exports = append(exports, content)
+	}
+
+	if string(exports[0]) != string(exports[1]) {
+		t.Fatalf("Expected spaces holding the same resources to produce the same export:\n%s\n%s", exports[0], exports[1])
+	}
+
+	AssertGoldenContent(t, exports[0], filepath.Join("testdata", "fake-space.golden.json"))
+}
+
+func TestGoldenFileMismatchesShowADiff(t *testing.T) {
+	t.Setenv("OCTOTESTUPDATEGOLDEN", "")
+
+	goldenFile := filepath.Join(t.TempDir(), "space.golden.json")
+	if err := os.WriteFile(goldenFile, []byte("{\n  \"Name\": \"Development\",\n  \"UseGuidedFailure\": false\n}\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	recording := &recordingT{TB: t}
+	AssertGoldenContent(recording, []byte("{\n  \"Name\": \"Development\",\n  \"UseGuidedFailure\": true\n}\n"), goldenFile)
+
+	if len(recording.failures) != 1 || !strings.HasSuffix(recording.failures[0], "@@ line 3 @@\n-  \"UseGuidedFailure\": false\n+  \"UseGuidedFailure\": true") {
+		t.Errorf("Expected a diff of the changed line, found %v", recording.failures)
+	}
+
+	t.Setenv("OCTOTESTUPDATEGOLDEN", "true")
+	AssertGoldenContent(t, []byte("{}\n"), goldenFile)
+
+	if content, err := os.ReadFile(goldenFile); err != nil || string(content) != "{}\n" {
+		t.Errorf("Expected the golden file to be updated, found %s", content)
+	}
+
+	t.Setenv("OCTOTESTUPDATEGOLDEN", "")
+	SetUpdateGoldenFiles(true)
+	defer SetUpdateGoldenFiles(false)
+	AssertGoldenContent(t, []byte("[]\n"), goldenFile)
+
+	if content, err := os.ReadFile(goldenFile); err != nil || string(content) != "[]\n" {
+		t.Errorf("Expected the golden file to be updated by SetUpdateGoldenFiles, found %s", content)
+	}
+}
+
+func TestUpdateFlagRewritesTheGoldenFiles(t *testing.T) {
+	t.Setenv("OCTOTESTUPDATEGOLDEN", "")
+	defer SetUpdateGoldenFiles(false)
+
+	RegisterUpdateFlag()
+
+	if err := flag.CommandLine.Set("update", "true"); err != nil {
+		t.Fatal(err)
+	}
+
+	if !updateGoldenFiles() {
+		t.Errorf("Expected -update to rewrite the golden files")
+	}
+
+	if err := flag.CommandLine.Set("update", "false"); err != nil {
+		t.Fatal(err)
+	}
+
+	if updateGoldenFiles() {
+		t.Errorf("Expected -update=false to compare the golden files")
+	}
+}
+
+func TestDiffsSkipTheUnchangedLines(t *testing.T) {
+	lines := []string{}
+	for i := 1; i <= 2000; i++ {
+		lines = append(lines, fmt.Sprintf("line %d", i))
+	}
+	expected := strings.Join(lines, "\n")
+
+	lines[1499] = "changed"
+	actual := strings.Join(lines, "\n")
+
+	if diff := diffLines(expected, actual); diff != "@@ line 1500 @@\n-line 1500\n+changed" {
+		t.Errorf("Expected a diff of the changed line, found %s", diff)
+	}
+}
+
+func TestLargeDiffsShowTheFirstDifference(t *testing.T) {
+	expectedLines := []string{"{"}
+	actualLines := []string{"{"}
+	for i := 0; i < 2000; i++ {
+		expectedLines = append(expectedLines, fmt.Sprintf("expected %d", i))
+		actualLines = append(actualLines, fmt.Sprintf("actual %d", i))
+	}
+
+	diff := diffLines(strings.Join(expectedLines, "\n"), strings.Join(actualLines, "\n"))
+
+	if diff != "@@ line 2 @@\n-expected 0\n+actual 0\n... 2000 lines removed and 2000 lines added are too many to compare" {
+		t.Errorf("Expected the first difference, found %s", diff)
+	}
+}
@@ -0,0 +1,184 @@
+package spaceexport
+
+import (
+	"encoding/json"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+/*
+	This file contains the normalisation applied to an export before it is compared to a golden file. The fields that
+	change each time a module is applied, such as IDs, timestamps, links, and versions, are removed or replaced, so two
+	spaces holding the same resources produce the same JSON.
+*/
+
+// volatileFields are removed from every object in the export
+var volatileFields = []string{"Id", "SpaceId", "Links", "Version", "LastModifiedOn", "LastModifiedBy"}
+
+// unorderedFields are arrays whose order is not meaningful, and are sorted. Variables are created by separate API
+// calls, so their order depends on the order terraform applied them in.
+var unorderedFields = []string{"Variables"}
+
+// timestampValue replaces every string holding a timestamp
+const timestampValue = "<timestamp>"
+
+// Normalise returns a copy of the export with the volatile fields removed, timestamps replaced, and the resources in each
+// collection sorted. Every ID is replaced with a reference to the resource it identifies, such as
+// "ref:environments/Development", so the export does not depend on the IDs assigned by the server. Resources without a
+// name, such as variable sets, are referenced through their owner, e.g. "ref:variables/ref:projects/My Project".
+func Normalise(space Space) Space {
+	references := resourceReferences(space)
+
+	result := Space{}
+	for collection, resources := range space {
+		normalised := make([]any, 0, len(resources))
+		for _, resource := range resources {
+			normalised = append(normalised, normaliseValue(resource, references))
+		}
+
+		sortByContent(normalised)
+
+		for _, resource := range normalised {
+			result[collection] = append(result[collection], resource.(map[string]any))
+		}
+	}
+
+	return result
+}
+
+// Marshal serialises the export as indented JSON. Object keys are sorted, so the output is stable.
+func Marshal(space Space) ([]byte, error) {
+	content, err := json.MarshalIndent(space, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	return append(content, '\n'), nil
+}
+
+// resourceReferences maps the ID of every resource to a reference built from its collection and name, such as
+// "ref:environments/Development". Resources scoped to a project, such as channels, include the project name, as
+// every project has a channel called "Default". Resources without a name are referenced through their owner.
+func resourceReferences(space Space) map[string]string {
+	references := map[string]string{}
+	projectNames := map[string]string{}
+	for _, project := range space["projects"] {
+		id, _ := project["Id"].(string)
+		projectNames[id], _ = project["Name"].(string)
+	}
+
+	collections := make([]string, 0, len(space))
+	for collection := range space {
+		collections = append(collections, collection)
+	}
+	sort.Strings(collections)
+
+	for _, collection := range collections {
+		for _, resource := range space[collection] {
+			id, _ := resource["Id"].(string)
+			name, _ := resource["Name"].(string)
+			projectId, _ := resource["ProjectId"].(string)
+
+			switch {
+			case id == "" || name == "":
+				continue
+			case projectNames[projectId] != "":
+				references[id] = "ref:" + collection + "/" + projectNames[projectId] + "/" + name
+			default:
+				references[id] = "ref:" + collection + "/" + name
+			}
+		}
+	}
+
+	// Owners are always named resources, so one pass resolves every unnamed resource
+	for _, collection := range collections {
+		for _, resource := range space[collection] {
+			id, _ := resource["Id"].(string)
+			if id == "" || references[id] != "" {
+				continue
+			}
+
+			for _, ownerField := range []string{"OwnerId", "RunbookId", "ProjectId"} {
+				if ownerId, _ := resource[ownerField].(string); references[ownerId] != "" {
+					references[id] = "ref:" + collection + "/" + references[ownerId]
+					break
+				}
+			}
+		}
+	}
+
+	return references
+}
+
+// normaliseValue returns a normalised copy of a JSON value
+func normaliseValue(value any, references map[string]string) any {
+	switch typed := value.(type) {
+	case map[string]any:
+		result := map[string]any{}
+		for key, child := range typed {
+			if slices.Contains(volatileFields, key) {
+				continue
+			}
+
+			// IDs are also used as keys, such as the projects a tenant is connected to
+			if reference, ok := references[key]; ok {
+				key = reference
+			}
+
+			normalised := normaliseValue(child, references)
+			if items, ok := normalised.([]any); ok && slices.Contains(unorderedFields, key) {
+				sortByContent(items)
+			}
+
+			result[key] = normalised
+		}
+		return result
+	case []any:
+		result := make([]any, 0, len(typed))
+		for _, child := range typed {
+			result = append(result, normaliseValue(child, references))
+		}
+		return result
+	case string:
+		if reference, ok := references[typed]; ok {
+			return reference
+		}
+
+		if _, err := time.Parse(time.RFC3339, typed); err == nil {
+			return timestampValue
+		}
+
+		return typed
+	default:
+		return value
+	}
+}
+
+// sortByContent sorts items by their name, and then by their JSON encoding
+func sortByContent(items []any) {
+	type keyedItem struct {
+		key  string
+		item any
+	}
+
+	keyed := make([]keyedItem, len(items))
+	for i, item := range items {
+		name := ""
+		if object, ok := item.(map[string]any); ok {
+			name, _ = object["Name"].(string)
+		}
+
+		content, _ := json.Marshal(item)
+		keyed[i] = keyedItem{key: name + "\x00" + string(content), item: item}
+	}
+
+	slices.SortStableFunc(keyed, func(a, b keyedItem) int {
+		return strings.Compare(a.key, b.key)
+	})
+
+	for i := range keyed {
+		items[i] = keyed[i].item
+	}
+}
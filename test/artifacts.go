@@ -0,0 +1,381 @@
+package test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	tcexec "github.com/testcontainers/testcontainers-go/exec"
+)
+
+/*
+	This file contains functions that write an artifact directory when a test fails. The containers are removed
+	once a test completes, so the artifacts are the only record of the server logs, the output of each terraform
+	command, the Terraform state, and the resources in each space at the time of the failure.
+*/
+
+// octopusServerLogsPath is the directory holding the Octopus server log files inside the Octopus container
+const octopusServerLogsPath = "/Octopus/Logs"
+
+// mssqlErrorLogPath is the MSSQL error log inside the MSSQL container
+const mssqlErrorLogPath = "/var/opt/mssql/log/errorlog"
+
+// artifactsTimeout is the time allowed to collect the artifacts, which may be written after the test context was cancelled
+const artifactsTimeout = 2 * time.Minute
+
+// unsafeFileNameCharacters matches the characters replaced when a test name is used as a directory name
+var unsafeFileNameCharacters = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// commandRecord is the output of a terraform command run by a test
+type commandRecord struct {
+	Dir    string
+	Args   []string
+	Stdout []byte
+	Stderr []byte
+	Err    error
+}
+
+func (o *OctopusContainerTest) getArtifactsDir() string {
+	if o.ArtifactsDir != "" {
+		return o.ArtifactsDir
+	}
+
+	if artifactsDir := os.Getenv("OCTOTESTARTIFACTSDIR"); artifactsDir != "" {
+		return artifactsDir
+	}
+
+	return filepath.Join(os.TempDir(), "octoterra-artifacts")
+}
+
+// setTestInstance records the instance, and the MSSQL container if there is one, that the test runs against.
+// These are the source of the logs and space resources written to the failure artifacts.
+func (o *OctopusContainerTest) setTestInstance(t *testing.T, instance OctopusInstance, sqlServer *MysqlContainer) {
+	state := getTestState(t)
+	state.mutex.Lock()
+	defer state.mutex.Unlock()
+
+	state.instance = instance
+	state.sqlServer = sqlServer
+}
+
+// commandOutput runs a command like cmnd.Output(), and records the stdout and stderr for the failure artifacts.
+// The complete stderr is returned in any exec.ExitError.
+func (o *OctopusContainerTest) commandOutput(t *testing.T, cmnd *exec.Cmd) ([]byte, error) {
+	stderr := bytes.Buffer{}
+	cmnd.Stderr = &stderr
+
+	out, err := cmnd.Output()
+
+	var exitError *exec.ExitError
+	if errors.As(err, &exitError) {
+		exitError.Stderr = stderr.Bytes()
+	}
+
+	state := getTestState(t)
+	state.mutex.Lock()
+	defer state.mutex.Unlock()
+
+	state.commands = append(state.commands, commandRecord{
+		Dir:    cmnd.Dir,
+		Args:   cmnd.Args,
+		Stdout: out,
+		Stderr: stderr.Bytes(),
+		Err:    err,
+	})
+
+	return out, err
+}
+
+// errTestFunctionExited is passed to finishTest when the test function did not return, such as when it called t.Fatal
+// or panicked
+var errTestFunctionExited = errors.New("the test function exited without returning")
+
+// runTestFunc runs the test function, and then finishTest. finishTest is deferred, so it runs before the instance is
+// removed even if the test function calls t.Fatal, which exits the goroutine, or panics.
+func (o *OctopusContainerTest) runTestFunc(ctx context.Context, t *testing.T, testFunc func() error) error {
+	failedBefore := t.Failed()
+	returned := false
+
+	defer func() {
+		if !returned && !t.Skipped() {
+			o.finishTest(ctx, t, errTestFunctionExited, failedBefore)
+		}
+	}()
+
+	err := testFunc()
+	returned = true
+
+	return o.finishTest(ctx, t, err, failedBefore)
+}
+
+// finishTest runs the actions registered against the test once the test function has returned. If the test function
+// returned an error, or failed the test with t.Errorf, the failure artifacts are written first, so they capture the
+// state and resources the actions would remove. failedBefore is true if the test had already failed before the test
+// function was run, such as by an earlier attempt.
+func (o *OctopusContainerTest) finishTest(ctx context.Context, t *testing.T, err error, failedBefore bool) error {
+	failed := err != nil || (t.Failed() && !failedBefore)
+	if failed {
+		o.writeFailureArtifacts(ctx, t)
+	}
+
+	afterTestErr := o.runAfterTest(t)
+	if !failed && afterTestErr != nil {
+		o.writeFailureArtifacts(ctx, t)
+	}
+
+	return errors.Join(err, afterTestErr)
+}
+
+// writeFailureArtifacts writes the logs, terraform output, state, and space resources captured for the test to a new
+// directory, and logs the location of the directory. Artifacts that can not be collected are noted in errors.txt.
+// Each failed attempt of a test gets its own directory.
+func (o *OctopusContainerTest) writeFailureArtifacts(ctx context.Context, t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), artifactsTimeout)
+	defer cancel()
+
+	baseDir := o.getArtifactsDir()
+	if err := os.MkdirAll(baseDir, 0o755); err != nil {
+		logRedacted(t, "Failed to create the artifacts directory: "+err.Error())
+		return
+	}
+
+	dir, err := os.MkdirTemp(baseDir, unsafeFileNameCharacters.ReplaceAllString(t.Name(), "_")+"-")
+	if err != nil {
+		logRedacted(t, "Failed to create the artifacts directory: "+err.Error())
+		return
+	}
+
+	state := getTestState(t)
+	state.mutex.Lock()
+	instance := state.instance
+	sqlServer := state.sqlServer
+	commands := state.commands
+	workingCopies := []string{}
+	for source := range state.workingCopies {
+		workingCopies = append(workingCopies, source)
+	}
+	// The next attempt only writes the commands it ran
+	state.commands = nil
+	state.mutex.Unlock()
+
+	errs := []error{
+		o.writeInstanceArtifacts(ctx, dir, instance),
+		writeMssqlArtifacts(ctx, dir, sqlServer),
+		writeCommandArtifacts(dir, commands),
+		o.writeStateArtifacts(ctx, t, dir, workingCopies),
+	}
+
+	if err := errors.Join(errs...); err != nil {
+		if writeErr := writeArtifact(filepath.Join(dir, "errors.txt"), []byte(err.Error())); writeErr != nil {
+			logRedacted(t, "Failed to write the artifact errors: "+writeErr.Error())
+		}
+	}
+
+	logRedacted(t, "Wrote the failure artifacts to "+dir)
+}
+
+// writeInstanceArtifacts writes the server logs and the resources in every space
+func (o *OctopusContainerTest) writeInstanceArtifacts(ctx context.Context, dir string, instance OctopusInstance) error {
+	if instance == nil {
+		return nil
+	}
+
+	var errs []error
+
+	logs, err := instance.GetLogs(ctx)
+	errs = append(errs, err, writeArtifact(filepath.Join(dir, "octopus.log"), []byte(logs)))
+
+	if container, ok := instance.(*OctopusContainer); ok {
+		errs = append(errs, copyContainerDir(ctx, container, octopusServerLogsPath, filepath.Join(dir, "octopus-server-logs")))
+	}
+
+	spaceIds, err := getSpaceIds(ctx, instance.GetURI(), instance.GetApiKey())
+	if err != nil {
+		return errors.Join(append(errs, err)...)
+	}
+
+	spacesDir := filepath.Join(dir, "spaces")
+	if err := os.MkdirAll(spacesDir, 0o755); err != nil {
+		return errors.Join(append(errs, err)...)
+	}
+
+	for _, spaceId := range spaceIds {
+		resources, err := o.getSpaceResources(ctx, instance.GetURI(), spaceId, true)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to read the resources in %s: %w", spaceId, err))
+			continue
+		}
+
+		errs = append(errs, writeJsonArtifact(filepath.Join(spacesDir, spaceId+".json"), resources))
+	}
+
+	return errors.Join(errs...)
+}
+
+// writeMssqlArtifacts writes the MSSQL container log and error log
+func writeMssqlArtifacts(ctx context.Context, dir string, sqlServer *MysqlContainer) error {
+	if sqlServer == nil {
+		return nil
+	}
+
+	var errs []error
+
+	logs, err := sqlServer.Logs(ctx)
+	if err == nil {
+		errs = append(errs, writeReaderArtifact(filepath.Join(dir, "mssql.log"), logs))
+	} else {
+		errs = append(errs, err)
+	}
+
+	errorLog, err := sqlServer.CopyFileFromContainer(ctx, mssqlErrorLogPath)
+	if err == nil {
+		errs = append(errs, writeReaderArtifact(filepath.Join(dir, "mssql-errorlog.txt"), errorLog))
+	} else {
+		errs = append(errs, err)
+	}
+
+	return errors.Join(errs...)
+}
+
+// writeCommandArtifacts writes the stdout and stderr of each terraform command, in the order they were run
+func writeCommandArtifacts(dir string, commands []commandRecord) error {
+	if len(commands) == 0 {
+		return nil
+	}
+
+	commandsDir := filepath.Join(dir, "terraform")
+	if err := os.MkdirAll(commandsDir, 0o755); err != nil {
+		return err
+	}
+
+	var errs []error
+	for i, command := range commands {
+		name := fmt.Sprintf("%02d", i+1)
+		if len(command.Args) > 1 {
+			name += "-" + command.Args[1]
+		}
+
+		content := "Directory: " + command.Dir + "\n" +
+			"Command: " + strings.Join(command.Args, " ") + "\n"
+		if command.Err != nil {
+			content += "Error: " + command.Err.Error() + "\n"
+		}
+		content += "\nStdout:\n" + string(command.Stdout) + "\nStderr:\n" + string(command.Stderr)
+
+		errs = append(errs, writeArtifact(filepath.Join(commandsDir, name+".log"), []byte(content)))
+	}
+
+	return errors.Join(errs...)
+}
+
+// writeStateArtifacts writes the output of "terraform show -json" for each module applied by the test
+func (o *OctopusContainerTest) writeStateArtifacts(ctx context.Context, t *testing.T, dir string, modules []string) error {
+	if len(modules) == 0 {
+		return nil
+	}
+
+	stateDir := filepath.Join(dir, "state")
+	if err := os.MkdirAll(stateDir, 0o755); err != nil {
+		return err
+	}
+
+	var errs []error
+	for i, module := range modules {
+		cmnd, err := o.terraformCommand(ctx, t, module, "show", "-json")
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+
+		// The output is not recorded, as it is not a step of the test
+		out, err := cmnd.Output()
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to show the state of %s: %w", module, err))
+			continue
+		}
+
+		// The state includes the values of sensitive outputs
+		state := struct {
+			Values struct {
+				Outputs map[string]TerraformOutput `json:"outputs"`
+			} `json:"values"`
+		}{}
+		if err := json.Unmarshal(out, &state); err == nil {
+			registerSensitiveOutputs(state.Values.Outputs)
+		}
+
+		name := fmt.Sprintf("%02d-%s.json", i+1, filepath.Base(module))
+		errs = append(errs, writeArtifact(filepath.Join(stateDir, name), out))
+	}
+
+	return errors.Join(errs...)
+}
+
+// copyContainerDir copies the files in a directory inside a container to a directory on the host
+func copyContainerDir(ctx context.Context, container *OctopusContainer, containerDir string, hostDir string) error {
+	exitCode, reader, err := container.Exec(ctx, []string{"find", containerDir, "-maxdepth", "1", "-type", "f"}, tcexec.Multiplexed())
+	if err != nil {
+		return err
+	}
+
+	output, err := io.ReadAll(reader)
+	if err != nil {
+		return err
+	}
+
+	if exitCode != 0 {
+		return fmt.Errorf("failed to list %s: %s", containerDir, string(output))
+	}
+
+	if err := os.MkdirAll(hostDir, 0o755); err != nil {
+		return err
+	}
+
+	var errs []error
+	for _, file := range strings.Fields(string(output)) {
+		content, err := container.CopyFileFromContainer(ctx, file)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+
+		errs = append(errs, writeReaderArtifact(filepath.Join(hostDir, filepath.Base(file)), content))
+	}
+
+	return errors.Join(errs...)
+}
+
+// writeArtifact writes an artifact file, masking any secrets
+func writeArtifact(file string, content []byte) error {
+	return os.WriteFile(file, []byte(Redact(string(content))), 0o644)
+}
+
+func writeReaderArtifact(file string, reader io.ReadCloser) error {
+	defer reader.Close()
+
+	content, err := io.ReadAll(reader)
+	if err != nil {
+		return err
+	}
+
+	return writeArtifact(file, content)
+}
+
+func writeJsonArtifact(file string, value any) error {
+	content, err := json.MarshalIndent(value, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return writeArtifact(file, content)
+}
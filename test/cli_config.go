@@ -0,0 +1,213 @@
+package test
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+/*
+	This file contains functions that generate a Terraform CLI configuration file for each test. Pointing
+	TF_CLI_CONFIG_FILE at a generated file allows a locally built provider or a provider mirror to be used
+	without editing the ~/.terraformrc file shared by every project on the machine.
+
+	It also manages the provider plugin cache shared by every test, so providers are downloaded once
+	rather than by every "terraform init".
+*/
+
+// defaultLocalProviderSource is the source address of the provider replaced by LocalProviderDir
+const defaultLocalProviderSource = "octopusdeploylabs/octopusdeploy"
+
+func (o *OctopusContainerTest) getLocalProviderDir() string {
+	if o.LocalProviderDir != "" {
+		return o.LocalProviderDir
+	}
+
+	return os.Getenv("OCTOTESTPROVIDERDIR")
+}
+
+// pluginCacheMutex guards writes to the plugin cache, as terraform does not support concurrent writes to the cache.
+// The first "terraform init" of each module holds the write lock while the module's providers are downloaded to the
+// cache. Later inits of the module only read the cached providers, so they share the read lock and run concurrently.
+var pluginCacheMutex = sync.RWMutex{}
+
+// cachedModules records the modules whose providers have been written to each plugin cache directory
+var cachedModules = map[cachedModule]bool{}
+
+type cachedModule struct {
+	pluginCacheDir string
+	moduleDir      string
+}
+
+func (o *OctopusContainerTest) getProviderMirrorDir() string {
+	if o.ProviderMirrorDir != "" {
+		return o.ProviderMirrorDir
+	}
+
+	return os.Getenv("OCTOTESTPROVIDERMIRROR")
+}
+
+func (o *OctopusContainerTest) getDisablePluginCache() bool {
+	return boolSetting(o.DisablePluginCache, "OCTOTESTDISABLEPLUGINCACHE", "true")
+}
+
+// getPluginCacheDir returns the provider plugin cache directory, or an empty string if the cache is disabled.
+// The cache defaults to a directory in the user cache directory, so it is shared by every test and every run.
+func (o *OctopusContainerTest) getPluginCacheDir() (string, error) {
+	if o.getDisablePluginCache() {
+		return "", nil
+	}
+
+	cacheDir := o.PluginCacheDir
+	if cacheDir == "" {
+		cacheDir = os.Getenv("OCTOTESTPLUGINCACHEDIR")
+	}
+	if cacheDir == "" {
+		cacheDir = os.Getenv("TF_PLUGIN_CACHE_DIR")
+	}
+	if cacheDir == "" {
+		userCacheDir, err := os.UserCacheDir()
+		if err != nil {
+			userCacheDir = os.TempDir()
+		}
+		cacheDir = filepath.Join(userCacheDir, "octoterra", "plugin-cache")
+	}
+
+	// terraform requires the cache directory to exist
+	if err := os.MkdirAll(cacheDir, 0o755); err != nil {
+		return "", err
+	}
+
+	return filepath.Abs(cacheDir)
+}
+
+// lockPluginCache runs init while holding the plugin cache lock. The write lock is held until init has succeeded once
+// for the module, and the read lock is held for every later init of the module. Working copies are resolved to the
+// module they were copied from, as every test inits its own copy of the same module.
+func (o *OctopusContainerTest) lockPluginCache(t *testing.T, terraformProjectDir string, init func() error) error {
+	pluginCacheDir, err := o.getPluginCacheDir()
+	if err != nil {
+		return err
+	}
+
+	if pluginCacheDir == "" {
+		return init()
+	}
+
+	key := cachedModule{pluginCacheDir: pluginCacheDir, moduleDir: o.getSourceModule(t, terraformProjectDir)}
+
+	pluginCacheMutex.RLock()
+	if cachedModules[key] {
+		defer pluginCacheMutex.RUnlock()
+		return init()
+	}
+	pluginCacheMutex.RUnlock()
+
+	pluginCacheMutex.Lock()
+	defer pluginCacheMutex.Unlock()
+
+	if err := init(); err != nil {
+		return err
+	}
+
+	cachedModules[key] = true
+	return nil
+}
+
+func (o *OctopusContainerTest) getLocalProviderSource() string {
+	if o.LocalProviderSource != "" {
+		return o.LocalProviderSource
+	}
+
+	return defaultLocalProviderSource
+}
+
+// buildCliConfig returns the contents of the CLI configuration file, or an empty string if the
+// settings do not require a CLI configuration file
+func (o *OctopusContainerTest) buildCliConfig() (string, error) {
+	providerDir := o.getLocalProviderDir()
+	mirrorDir := o.getProviderMirrorDir()
+	if providerDir == "" && mirrorDir == "" {
+		return "", nil
+	}
+
+	// HCL strings support the same escape sequences as Go strings
+	config := []string{"provider_installation {"}
+
+	if providerDir != "" {
+		absProviderDir, err := filepath.Abs(providerDir)
+		if err != nil {
+			return "", err
+		}
+
+		config = append(config,
+			"  dev_overrides {",
+			"    "+strconv.Quote(o.getLocalProviderSource())+" = "+strconv.Quote(absProviderDir),
+			"  }")
+	}
+
+	// Providers are only installed from the mirror when it is defined, so no network access is required
+	if mirrorDir != "" {
+		absMirrorDir, err := filepath.Abs(mirrorDir)
+		if err != nil {
+			return "", err
+		}
+
+		config = append(config,
+			"  filesystem_mirror {",
+			"    path = "+strconv.Quote(absMirrorDir),
+			"  }")
+	} else {
+		config = append(config, "  direct {}")
+	}
+
+	return strings.Join(append(config, "}", ""), "\n"), nil
+}
+
+// cliEnvironment returns the environment variables that point terraform at the plugin cache and the CLI configuration
+// file generated for the test. The file is written the first time it is required, and removed once the test completes.
+func (o *OctopusContainerTest) cliEnvironment(t *testing.T) ([]string, error) {
+	environment := []string{}
+
+	pluginCacheDir, err := o.getPluginCacheDir()
+	if err != nil {
+		return nil, err
+	}
+
+	if pluginCacheDir != "" {
+		// The lock files are deleted before each test, so terraform can not verify the cached providers against them
+		environment = append(environment,
+			"TF_PLUGIN_CACHE_DIR="+pluginCacheDir,
+			"TF_PLUGIN_CACHE_MAY_BREAK_DEPENDENCY_LOCK_FILE=true")
+	}
+
+	config, err := o.buildCliConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	if config == "" {
+		return environment, nil
+	}
+
+	state := getTestState(t)
+	state.mutex.Lock()
+	defer state.mutex.Unlock()
+
+	if state.cliConfigDir == "" {
+		state.cliConfigDir, err = os.MkdirTemp("", "octoterra_cli_config")
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	configFile := filepath.Join(state.cliConfigDir, "terraform.tfrc")
+	if err := os.WriteFile(configFile, []byte(config), 0o644); err != nil {
+		return nil, err
+	}
+
+	return append(environment, "TF_CLI_CONFIG_FILE="+configFile), nil
+}
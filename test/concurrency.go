@@ -0,0 +1,117 @@
+package test
+
+import (
+	"bufio"
+	"context"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+/*
+	This file contains functions that limit the number of Octopus stacks running at once. Tests calling t.Parallel()
+	each create their own stack, and each stack runs Octopus and MSSQL, so running too many at once exhausts the
+	memory of the machine running the tests.
+*/
+
+// stackMemoryBytes is the approximate memory used by an Octopus container and a MSSQL container
+const stackMemoryBytes = 4 * 1024 * 1024 * 1024
+
+// defaultMaxConcurrentStacks is the limit used when the available memory can not be determined
+const defaultMaxConcurrentStacks = 2
+
+// memoryStackLimit is the limit derived from the available memory. It is read once, before any stacks are
+// started, as the memory used by running stacks would otherwise reduce the limit as more stacks start.
+var memoryStackLimit = sync.OnceValue(func() int {
+	available, ok := getAvailableMemory()
+	if !ok {
+		return defaultMaxConcurrentStacks
+	}
+
+	return max(1, int(available/stackMemoryBytes))
+})
+
+var runningStacks = 0
+var runningStacksMutex = sync.Mutex{}
+
+// stackReleased is closed, and replaced, each time a stack is released, waking any tests waiting for a stack
+var stackReleased = make(chan struct{})
+
+// getMaxConcurrentStacks returns the maximum number of stacks created by ArrangeTest or the stack pool that can run at once.
+// The default allows one stack for every 4GB of memory available when the first stack is requested.
+func (o *OctopusContainerTest) getMaxConcurrentStacks() int {
+	if o.MaxConcurrentStacks > 0 {
+		return o.MaxConcurrentStacks
+	}
+
+	if limit, err := strconv.Atoi(os.Getenv("OCTOTESTMAXSTACKS")); err == nil && limit > 0 {
+		return limit
+	}
+
+	return memoryStackLimit()
+}
+
+// getAvailableMemory returns the memory available to new processes, as reported by /proc/meminfo.
+// The second return value is false on platforms that do not report the available memory.
+func getAvailableMemory() (uint64, bool) {
+	file, err := os.Open("/proc/meminfo")
+	if err != nil {
+		return 0, false
+	}
+	defer file.Close()
+
+	return parseAvailableMemory(bufio.NewScanner(file))
+}
+
+// parseAvailableMemory reads the MemAvailable line from the contents of /proc/meminfo
+func parseAvailableMemory(scanner *bufio.Scanner) (uint64, bool) {
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 || fields[0] != "MemAvailable:" {
+			continue
+		}
+
+		kilobytes, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			return 0, false
+		}
+
+		return kilobytes * 1024, true
+	}
+
+	return 0, false
+}
+
+// acquireStack waits until fewer than the maximum number of stacks are running, or the context is cancelled.
+// Every successful call must be matched by a call to releaseStack.
+func (o *OctopusContainerTest) acquireStack(ctx context.Context) error {
+	limit := o.getMaxConcurrentStacks()
+
+	for {
+		runningStacksMutex.Lock()
+		if runningStacks < limit {
+			runningStacks++
+			runningStacksMutex.Unlock()
+			return nil
+		}
+		released := stackReleased
+		runningStacksMutex.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return phaseError(ctx, "waiting for one of the "+strconv.Itoa(limit)+" running stacks to finish", ctx.Err())
+		case <-released:
+		}
+	}
+}
+
+// releaseStack allows another stack to be created
+func (o *OctopusContainerTest) releaseStack() {
+	runningStacksMutex.Lock()
+	defer runningStacksMutex.Unlock()
+
+	runningStacks--
+	close(stackReleased)
+	stackReleased = make(chan struct{})
+}
@@ -0,0 +1,237 @@
+package test
+
+import (
+	"context"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/testcontainers/testcontainers-go"
+)
+
+/*
+	This file contains the log consumer that routes container logs to the test using the container. Each line is
+	prefixed with the time it was received and the role of the container, and lines below the configured level
+	are dropped. Lines received while no test is using the container, such as while a shared stack starts, are
+	written to the standard logger. Secrets are masked in every line.
+
+	Tests running in parallel against a shared stack overlap, so each line is routed to the most recently started
+	test that is still running. Once that test completes, lines are routed to the previous test that is still running.
+*/
+
+// LogLevel is the minimum level of the container log lines that are displayed
+type LogLevel string
+
+const (
+	// LogLevelNone hides every line
+	LogLevelNone LogLevel = "none"
+	// LogLevelError displays errors
+	LogLevelError LogLevel = "error"
+	// LogLevelWarn displays warnings and errors
+	LogLevelWarn LogLevel = "warn"
+	// LogLevelInfo displays every line except debug and trace lines
+	LogLevelInfo LogLevel = "info"
+	// LogLevelDebug displays every line
+	LogLevelDebug LogLevel = "debug"
+)
+
+// logLevels are the log levels in order of verbosity
+var logLevels = []LogLevel{LogLevelNone, LogLevelError, LogLevelWarn, LogLevelInfo, LogLevelDebug}
+
+// logLineLevel matches the level written near the start of a log line by Octopus and MSSQL
+var logLineLevel = regexp.MustCompile(`(?i)\b(fatal|error|warn|warning|info|information|debug|trace|verbose)\b`)
+
+// logLevelPrefixLength is the number of characters at the start of a line searched for a level, so that
+// the message itself, such as "retrying after error", does not change the level of the line
+const logLevelPrefixLength = 64
+
+// rank returns the position of the level in logLevels. Unknown levels are treated as LogLevelInfo.
+func (l LogLevel) rank() int {
+	if index := slices.Index(logLevels, LogLevel(strings.ToLower(string(l)))); index >= 0 {
+		return index
+	}
+
+	return slices.Index(logLevels, LogLevelInfo)
+}
+
+// getLineLevel returns the level of a log line. Lines without a level are treated as LogLevelInfo.
+func getLineLevel(line string) LogLevel {
+	match := logLineLevel.FindString(line[:min(len(line), logLevelPrefixLength)])
+
+	switch strings.ToLower(match) {
+	case "fatal", "error":
+		return LogLevelError
+	case "warn", "warning":
+		return LogLevelWarn
+	case "debug", "trace", "verbose":
+		return LogLevelDebug
+	default:
+		return LogLevelInfo
+	}
+}
+
+// ContainerLogConsumer routes the log lines of a container to the test that is using the container,
+// or to a file for each test if a log directory is defined
+type ContainerLogConsumer struct {
+	role   string
+	level  LogLevel
+	logDir string
+	mutex  sync.Mutex
+	// targets are the running tests attached to the consumer, with the most recently attached test last
+	targets []*logTarget
+}
+
+// logTarget is a test receiving container log lines, and the file the lines are written to if a log directory is defined
+type logTarget struct {
+	t    *testing.T
+	file *os.File
+}
+
+// NewContainerLogConsumer creates a log consumer for a container with the supplied role, such as "octopus" or "mssql".
+// Lines are written to a file for each test in logDir if it is not empty.
+func NewContainerLogConsumer(role string, level LogLevel, logDir string) *ContainerLogConsumer {
+	return &ContainerLogConsumer{role: role, level: level, logDir: logDir}
+}
+
+// Accept receives a log line from the container
+func (c *ContainerLogConsumer) Accept(l testcontainers.Log) {
+	line := strings.TrimRight(string(l.Content), "\r\n")
+	if getLineLevel(line).rank() > c.level.rank() {
+		return
+	}
+
+	line = time.Now().Format("15:04:05.000") + " [" + c.role + "] " + Redact(line)
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if len(c.targets) == 0 {
+		log.Println(line)
+		return
+	}
+
+	target := c.targets[len(c.targets)-1]
+	if target.file == nil {
+		target.t.Log(line)
+		return
+	}
+
+	if _, err := target.file.WriteString(line + "\n"); err != nil {
+		log.Println("Failed to write the " + c.role + " container log: " + err.Error())
+	}
+}
+
+// attach routes the log lines to the test until it completes, or until another test is attached while it runs
+func (c *ContainerLogConsumer) attach(t *testing.T) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	target := &logTarget{t: t}
+	c.targets = append(c.targets, target)
+
+	if c.logDir != "" {
+		if err := os.MkdirAll(c.logDir, 0o755); err != nil {
+			logRedacted(t, "Failed to create the container log directory: "+err.Error())
+		} else {
+			file := filepath.Join(c.logDir, unsafeFileNameCharacters.ReplaceAllString(t.Name(), "_")+"."+c.role+".log")
+			if target.file, err = os.OpenFile(file, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644); err != nil {
+				logRedacted(t, "Failed to open the container log file: "+err.Error())
+			} else {
+				logRedacted(t, "Writing the "+c.role+" container log to "+file)
+			}
+		}
+	}
+
+	// Calling t.Log once a test has completed panics, so stop routing lines to the test before it completes
+	t.Cleanup(func() {
+		c.detach(t)
+	})
+}
+
+// detach stops routing log lines to the test, if it is still attached
+func (c *ContainerLogConsumer) detach(t *testing.T) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.targets = slices.DeleteFunc(c.targets, func(target *logTarget) bool {
+		if target.t != t {
+			return false
+		}
+
+		if target.file != nil {
+			if err := target.file.Close(); err != nil {
+				log.Println("Failed to close the " + c.role + " container log: " + err.Error())
+			}
+		}
+
+		return true
+	})
+}
+
+// getLogLevel returns the log level for a container, falling back to the environment variable and then to LogLevelInfo.
+// The deprecated setting disabling the container logs is respected if no level is set.
+func getLogLevel(level LogLevel, envVar string, disabled bool) LogLevel {
+	if level != "" {
+		return level
+	}
+
+	if disabled {
+		return LogLevelNone
+	}
+
+	if envLevel := os.Getenv(envVar); envLevel != "" {
+		return LogLevel(envLevel)
+	}
+
+	return LogLevelInfo
+}
+
+func (o *OctopusContainerTest) getOctopusContainerLogLevel() LogLevel {
+	return getLogLevel(o.OctopusContainerLogLevel, "OCTOTESTOCTOPUSLOGLEVEL", o.getDisableOctopusContainerLogging())
+}
+
+func (o *OctopusContainerTest) getMSSQLContainerLogLevel() LogLevel {
+	return getLogLevel(o.MSSQLContainerLogLevel, "OCTOTESTMSSQLLOGLEVEL", o.getDisableMSSQLContainerLogging())
+}
+
+func (o *OctopusContainerTest) getContainerLogDir() string {
+	if o.ContainerLogDir != "" {
+		return o.ContainerLogDir
+	}
+
+	return os.Getenv("OCTOTESTCONTAINERLOGDIR")
+}
+
+// enableContainerLogging starts following the container logs, returning the consumer, or nil if
+// the logs of the container are hidden
+func (o *OctopusContainerTest) enableContainerLogging(container testcontainers.Container, ctx context.Context, role string, level LogLevel) *ContainerLogConsumer {
+	if level.rank() == LogLevelNone.rank() {
+		return nil
+	}
+
+	if err := container.StartLogProducer(ctx); err != nil {
+		log.Println("Failed to follow the " + role + " container logs: " + err.Error())
+		return nil
+	}
+
+	consumer := NewContainerLogConsumer(role, level, o.getContainerLogDir())
+	container.FollowOutput(consumer)
+	return consumer
+}
+
+// routeContainerLogs sends the logs of the containers in a stack to the test using the stack
+func routeContainerLogs(t *testing.T, octopusContainer *OctopusContainer, sqlServer *MysqlContainer) {
+	if octopusContainer != nil && octopusContainer.logConsumer != nil {
+		octopusContainer.logConsumer.attach(t)
+	}
+
+	if sqlServer != nil && sqlServer.logConsumer != nil {
+		sqlServer.logConsumer.attach(t)
+	}
+}
@@ -0,0 +1,103 @@
+package test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"testing"
+	"time"
+)
+
+// maxDeadlineGracePeriod is the longest time before the test deadline that the context returned by TestContext
+// is cancelled. Cancelling before the deadline gives the framework time to report which phase hung before
+// "go test" panics and kills the test binary.
+const maxDeadlineGracePeriod = 30 * time.Second
+
+// terraformWaitDelay is how long a terraform process has to exit after being interrupted before it is killed
+const terraformWaitDelay = 30 * time.Second
+
+// TimeoutError is returned when a phase of a test did not complete before the context was cancelled
+type TimeoutError struct {
+	Phase string
+	Err   error
+}
+
+func (e *TimeoutError) Error() string {
+	return fmt.Sprintf("timed out while %s: %v", e.Phase, e.Err)
+}
+
+func (e *TimeoutError) Unwrap() error {
+	return e.Err
+}
+
+// TestContext returns a context that is cancelled shortly before the test deadline, or when the test completes.
+// This is the context used by the functions that do not accept a context.
+func TestContext(t *testing.T) context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	if deadline, ok := t.Deadline(); ok {
+		gracePeriod := time.Until(deadline) / 10
+		if gracePeriod > maxDeadlineGracePeriod {
+			gracePeriod = maxDeadlineGracePeriod
+		}
+
+		cancel()
+		ctx, cancel = context.WithDeadline(context.Background(), deadline.Add(-gracePeriod))
+	}
+
+	t.Cleanup(cancel)
+
+	return ctx
+}
+
+// phaseError wraps the error in a TimeoutError naming the phase if the context was cancelled
+func phaseError(ctx context.Context, phase string, err error) error {
+	if err == nil {
+		return nil
+	}
+
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return &TimeoutError{Phase: phase, Err: ctxErr}
+	}
+
+	return err
+}
+
+// httpGetContext performs a GET request that is cancelled with the context
+func httpGetContext(ctx context.Context, url string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return http.DefaultClient.Do(req)
+}
+
+// checkApiContext returns an error if the URL does not respond with a 2xx status code
+func checkApiContext(ctx context.Context, url string) error {
+	response, err := httpGetContext(ctx, url)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if !(response.StatusCode >= 200 && response.StatusCode <= 299) {
+		return fmt.Errorf("non 2xx status code %d returned", response.StatusCode)
+	}
+
+	return nil
+}
+
+// interruptOnCancel configures a command to be interrupted rather than killed when the context is cancelled,
+// giving terraform the chance to release state locks. Processes that do not exit are killed after terraformWaitDelay.
+func interruptOnCancel(cmnd *exec.Cmd) {
+	cmnd.Cancel = func() error {
+		if err := cmnd.Process.Signal(os.Interrupt); err != nil {
+			return cmnd.Process.Kill()
+		}
+		return nil
+	}
+	cmnd.WaitDelay = terraformWaitDelay
+}
@@ -0,0 +1,189 @@
+package test
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	b64 "encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"sort"
+	"sync"
+
+	tcexec "github.com/testcontainers/testcontainers-go/exec"
+)
+
+/*
+	This file contains functions that snapshot the Octopus database once the first Octopus server has started,
+	and restore the snapshot into the MSSQL container of every subsequent stack. Starting Octopus against a
+	restored database skips the migrations run against an empty database, which reduces the startup time
+	from minutes to seconds.
+
+	The snapshots contain the license and sensitive values, so they are only held in memory and are never written
+	to the host.
+*/
+
+// snapshotContainerPath is the path of the backup file inside the MSSQL container
+const snapshotContainerPath = "/var/opt/mssql/data/OctopusDeploy.bak"
+
+// databaseSnapshots maps a snapshot key to the contents of the backup file
+var databaseSnapshots = map[string][]byte{}
+
+// snapshotsInProgress holds the keys of the snapshots being taken, so the database is only backed up once for each key
+var snapshotsInProgress = map[string]bool{}
+var databaseSnapshotsMutex = sync.Mutex{}
+
+// masterKey is shared by every Octopus server started by this process. Sensitive values in a snapshot are
+// encrypted with the master key, so servers started from the snapshot must use the same key.
+var masterKey = sync.OnceValue(func() string {
+	key := make([]byte, 16)
+	if _, err := rand.Read(key); err != nil {
+		panic(err)
+	}
+	return b64.StdEncoding.EncodeToString(key)
+})
+
+func (o *OctopusContainerTest) getUseDatabaseSnapshot() bool {
+	return boolSetting(o.UseDatabaseSnapshot, "OCTOTESTDBSNAPSHOT", "true")
+}
+
+// getSnapshotKey identifies the snapshots that can be restored for the current settings. A snapshot can only be
+// restored into the same version of MSSQL and Octopus, with the same admin API key, license, and custom environment.
+func (o *OctopusContainerTest) getSnapshotKey() string {
+	hash := sha256.New()
+	hash.Write([]byte(o.getOctopusImageUrl() + ":" + o.getOctopusVersion() + "\n"))
+	hash.Write([]byte(o.getMSSQLTaggedVersion() + "\n"))
+	hash.Write([]byte(o.GetApiKey() + "\n"))
+	hash.Write([]byte(o.getLicense() + "\n"))
+
+	keys := make([]string, 0, len(o.CustomEnvironment))
+	for key := range o.CustomEnvironment {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		hash.Write([]byte(key + "=" + o.CustomEnvironment[key] + "\n"))
+	}
+
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// getDatabaseSnapshot returns the snapshot matching the current settings, if one exists
+func (o *OctopusContainerTest) getDatabaseSnapshot() ([]byte, bool) {
+	databaseSnapshotsMutex.Lock()
+	defer databaseSnapshotsMutex.Unlock()
+
+	snapshot, ok := databaseSnapshots[o.getSnapshotKey()]
+	return snapshot, ok
+}
+
+// sqlCommand runs a SQL command in the MSSQL container, returning the output as part of any error
+func (o *OctopusContainerTest) sqlCommand(ctx context.Context, sqlServer *MysqlContainer, query string) error {
+	exitCode, reader, err := sqlServer.Exec(
+		ctx,
+		[]string{"/opt/mssql-tools18/bin/sqlcmd", "-C", "-b", "-U", "sa", "-P", defaultPassword, "-Q", query},
+		tcexec.Multiplexed())
+
+	if err != nil {
+		return err
+	}
+
+	if exitCode != 0 {
+		output, _ := io.ReadAll(reader)
+		return fmt.Errorf("sqlcmd returned exit code %d: %s", exitCode, string(output))
+	}
+
+	return nil
+}
+
+// restoreDatabaseSnapshot restores the snapshot matching the current settings into the MSSQL container.
+// The return value is false if snapshots are disabled or no snapshot has been taken yet.
+func (o *OctopusContainerTest) restoreDatabaseSnapshot(ctx context.Context, sqlServer *MysqlContainer) (bool, error) {
+	if !o.getUseDatabaseSnapshot() {
+		return false, nil
+	}
+
+	snapshot, ok := o.getDatabaseSnapshot()
+	if !ok {
+		return false, nil
+	}
+
+	log.Println("Restoring the database snapshot")
+
+	if err := sqlServer.CopyToContainer(ctx, snapshot, snapshotContainerPath, 0o644); err != nil {
+		return false, phaseError(ctx, "copying the database snapshot", err)
+	}
+
+	if err := o.sqlCommand(ctx, sqlServer, "RESTORE DATABASE [OctopusDeploy] FROM DISK = N'"+snapshotContainerPath+"' WITH REPLACE"); err != nil {
+		return false, phaseError(ctx, "restoring the database snapshot", err)
+	}
+
+	return true, nil
+}
+
+// takeDatabaseSnapshot backs up the Octopus database and keeps the backup in memory. This is done once for
+// each combination of settings, after the first Octopus server has started. The lock is only held to reserve and
+// store the snapshot, so the backup does not block other stacks. Stacks started while the backup is in progress
+// start from an empty database.
+func (o *OctopusContainerTest) takeDatabaseSnapshot(ctx context.Context, sqlServer *MysqlContainer) error {
+	if !o.getUseDatabaseSnapshot() {
+		return nil
+	}
+
+	key := o.getSnapshotKey()
+
+	databaseSnapshotsMutex.Lock()
+	_, taken := databaseSnapshots[key]
+	if taken || snapshotsInProgress[key] {
+		databaseSnapshotsMutex.Unlock()
+		return nil
+	}
+	snapshotsInProgress[key] = true
+	databaseSnapshotsMutex.Unlock()
+
+	backup, err := o.backupDatabase(ctx, sqlServer)
+
+	databaseSnapshotsMutex.Lock()
+	defer databaseSnapshotsMutex.Unlock()
+
+	// A failed backup is retried by the next stack to start
+	delete(snapshotsInProgress, key)
+	if err != nil {
+		return err
+	}
+
+	databaseSnapshots[key] = backup
+
+	log.Printf("Saved a %d byte database snapshot", len(backup))
+
+	return nil
+}
+
+// backupDatabase backs up the Octopus database and returns the contents of the backup file
+func (o *OctopusContainerTest) backupDatabase(ctx context.Context, sqlServer *MysqlContainer) ([]byte, error) {
+	log.Println("Taking a snapshot of the Octopus database")
+
+	if err := o.sqlCommand(ctx, sqlServer, "BACKUP DATABASE [OctopusDeploy] TO DISK = N'"+snapshotContainerPath+"' WITH INIT, COPY_ONLY"); err != nil {
+		return nil, phaseError(ctx, "backing up the database", err)
+	}
+
+	reader, err := sqlServer.CopyFileFromContainer(ctx, snapshotContainerPath)
+	if err != nil {
+		return nil, phaseError(ctx, "copying the database snapshot", err)
+	}
+	defer reader.Close()
+
+	return io.ReadAll(reader)
+}
+
+// DeleteDatabaseSnapshots releases the memory held by the database snapshots. RunWithSharedStack and RunWithStackPool
+// call this automatically. The snapshots are never written to the host, so calling it is optional.
+func DeleteDatabaseSnapshots() {
+	databaseSnapshotsMutex.Lock()
+	defer databaseSnapshotsMutex.Unlock()
+
+	clear(databaseSnapshots)
+}
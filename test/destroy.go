@@ -0,0 +1,86 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"testing"
+)
+
+// appliedModule records a module applied by InitialiseOctopus so it can be destroyed after the test
+type appliedModule struct {
+	dir     string
+	spaceId string
+	vars    []string
+}
+
+func (o *OctopusContainerTest) getDestroyAfterTest() bool {
+	return boolSetting(o.DestroyAfterTest, "OCTOTESTDESTROY", "true")
+}
+
+// TerraformDestroy runs "terraform destroy"
+func (o *OctopusContainerTest) TerraformDestroy(t *testing.T, terraformProjectDir string, server string, spaceId string, vars []string) error {
+	return o.TerraformDestroyContext(TestContext(t), t, terraformProjectDir, server, spaceId, vars)
+}
+
+// TerraformDestroyContext runs "terraform destroy", interrupting terraform if the context is cancelled
+func (o *OctopusContainerTest) TerraformDestroyContext(ctx context.Context, t *testing.T, terraformProjectDir string, server string, spaceId string, vars []string) error {
+	varArgs, varEnv, err := o.terraformVarArgs(t, server, spaceId, vars)
+	if err != nil {
+		return err
+	}
+
+	newArgs := append([]string{
+		"destroy",
+		"-auto-approve",
+		"-no-color",
+	}, varArgs...)
+
+	cmnd, err := o.terraformCommand(ctx, t, terraformProjectDir, newArgs...)
+	if err != nil {
+		return err
+	}
+	cmnd.Env = append(cmnd.Env, varEnv...)
+
+	out, err := o.commandOutput(t, cmnd)
+
+	logRedacted(t, string(out))
+
+	if err != nil {
+		exitError, ok := err.(*exec.ExitError)
+		if ok {
+			logRedacted(t, "terraform destroy error")
+			logRedacted(t, string(exitError.Stderr))
+		} else {
+			logRedacted(t, err)
+		}
+		return phaseError(ctx, "running terraform destroy in "+terraformProjectDir, err)
+	}
+
+	return nil
+}
+
+// destroyModules destroys the modules in the reverse order to which they were applied, and then verifies
+// that the space was either deleted or has no resources left in it. A module that fails to be destroyed does not
+// stop the remaining modules from being destroyed, but the space is only verified if every module was destroyed.
+func (o *OctopusContainerTest) destroyModules(ctx context.Context, t *testing.T, server string, modules []appliedModule, spaceId string) error {
+	var errs []error
+	for i := len(modules) - 1; i >= 0; i-- {
+		module := modules[i]
+
+		logRedacted(t, "DESTROYING MODULE "+module.dir)
+
+		err := o.TerraformDestroyContext(ctx, t, module.dir, server, module.spaceId, module.vars)
+
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to destroy the module %s: %w", module.dir, err))
+		}
+	}
+
+	if len(errs) != 0 {
+		return errors.Join(errs...)
+	}
+
+	return phaseError(ctx, "verifying space "+spaceId+" is empty", o.verifySpaceIsEmpty(ctx, server, spaceId))
+}
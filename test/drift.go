@@ -0,0 +1,78 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"os/exec"
+	"testing"
+)
+
+// DriftError is returned when "terraform plan" reports changes immediately after a module was applied.
+// This usually indicates a provider bug or a module that can never converge.
+type DriftError struct {
+	Dir  string
+	Plan string
+}
+
+func (e *DriftError) Error() string {
+	return "terraform plan reported changes after applying the module in " + e.Dir + ":\n" + e.Plan
+}
+
+// isRetryable returns false for errors that will not be fixed by running the test again
+func isRetryable(err error) bool {
+	var driftError *DriftError
+	var roundTripError *RoundTripError
+	return !errors.As(err, &driftError) && !errors.As(err, &roundTripError)
+}
+
+// AssertNoDrift runs "terraform plan -detailed-exitcode" against a module that has been applied, and
+// returns a DriftError containing the rendered plan if terraform reports any changes.
+func (o *OctopusContainerTest) AssertNoDrift(t *testing.T, terraformProjectDir string, server string, spaceId string, vars []string) error {
+	return o.AssertNoDriftContext(TestContext(t), t, terraformProjectDir, server, spaceId, vars)
+}
+
+// AssertNoDriftContext is the same as AssertNoDrift, but interrupts terraform if the context is cancelled
+func (o *OctopusContainerTest) AssertNoDriftContext(ctx context.Context, t *testing.T, terraformProjectDir string, server string, spaceId string, vars []string) error {
+	varArgs, varEnv, err := o.terraformVarArgs(t, server, spaceId, vars)
+	if err != nil {
+		return err
+	}
+
+	newArgs := append([]string{
+		"plan",
+		"-detailed-exitcode",
+		"-input=false",
+		"-no-color",
+	}, varArgs...)
+
+	cmnd, err := o.terraformCommand(ctx, t, terraformProjectDir, newArgs...)
+	if err != nil {
+		return err
+	}
+	cmnd.Env = append(cmnd.Env, varEnv...)
+
+	out, err := o.commandOutput(t, cmnd)
+
+	if err != nil {
+		exitError, ok := err.(*exec.ExitError)
+
+		// An exit code of 2 means the plan succeeded and there are changes
+		if ok && exitError.ExitCode() == 2 && ctx.Err() == nil {
+			driftError := &DriftError{Dir: terraformProjectDir, Plan: string(out)}
+			logRedacted(t, driftError.Error())
+			return driftError
+		}
+
+		logRedacted(t, string(out))
+
+		if ok {
+			logRedacted(t, "terraform plan error: "+string(exitError.Stderr))
+		} else {
+			logRedacted(t, err)
+		}
+
+		return phaseError(ctx, "running terraform plan in "+terraformProjectDir, err)
+	}
+
+	return nil
+}
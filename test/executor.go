@@ -0,0 +1,129 @@
+package test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// IacExecutor describes the infrastructure as code tool used to apply modules. This allows the same
+// modules to be tested with both Terraform and OpenTofu.
+type IacExecutor interface {
+	// Name is the name of the tool, used in log messages
+	Name() string
+	// Binary is the name or path of the executable
+	Binary() string
+	// Environment returns any environment variables, in the form "KEY=value", passed to the executable
+	Environment() []string
+	// Version returns the version reported by the executable
+	Version(ctx context.Context) (string, error)
+}
+
+// TerraformExecutor runs modules with Terraform. This is the default executor.
+type TerraformExecutor struct {
+	// Path is the path to the terraform executable. Defaults to "terraform".
+	Path string
+}
+
+func (e TerraformExecutor) Name() string {
+	return "terraform"
+}
+
+func (e TerraformExecutor) Binary() string {
+	if e.Path == "" {
+		return "terraform"
+	}
+
+	return e.Path
+}
+
+func (e TerraformExecutor) Environment() []string {
+	return []string{}
+}
+
+func (e TerraformExecutor) Version(ctx context.Context) (string, error) {
+	return getExecutorVersion(ctx, e.Binary())
+}
+
+// OpenTofuExecutor runs modules with OpenTofu
+type OpenTofuExecutor struct {
+	// Path is the path to the tofu executable. Defaults to "tofu".
+	Path string
+	// EncryptionConfig is the HCL state encryption configuration passed in the TF_ENCRYPTION
+	// environment variable. State is not encrypted if this is empty.
+	EncryptionConfig string
+}
+
+func (e OpenTofuExecutor) Name() string {
+	return "tofu"
+}
+
+func (e OpenTofuExecutor) Binary() string {
+	if e.Path == "" {
+		return "tofu"
+	}
+
+	return e.Path
+}
+
+func (e OpenTofuExecutor) Environment() []string {
+	if e.EncryptionConfig == "" {
+		return []string{}
+	}
+
+	return []string{"TF_ENCRYPTION=" + e.EncryptionConfig}
+}
+
+func (e OpenTofuExecutor) Version(ctx context.Context) (string, error) {
+	return getExecutorVersion(ctx, e.Binary())
+}
+
+// getExecutorVersion runs "version -json" and returns the reported version
+func getExecutorVersion(ctx context.Context, binary string) (string, error) {
+	out, err := exec.CommandContext(ctx, binary, "version", "-json").Output()
+	if err != nil {
+		return "", err
+	}
+
+	return parseExecutorVersion(out)
+}
+
+// parseExecutorVersion extracts the version from the output of "version -json"
+func parseExecutorVersion(out []byte) (string, error) {
+	version := struct {
+		TerraformVersion string `json:"terraform_version"`
+		TofuVersion      string `json:"tofu_version"`
+	}{}
+
+	if err := json.Unmarshal(out, &version); err != nil {
+		return "", err
+	}
+
+	if version.TofuVersion != "" {
+		return version.TofuVersion, nil
+	}
+
+	if version.TerraformVersion != "" {
+		return version.TerraformVersion, nil
+	}
+
+	return "", errors.New("the version was not found in the output: " + string(out))
+}
+
+// getExecutor returns the executor used to apply modules
+func (o *OctopusContainerTest) getExecutor() IacExecutor {
+	if o.Executor != nil {
+		return o.Executor
+	}
+
+	binary := os.Getenv("OCTOTESTIACBINARY")
+	if strings.HasPrefix(filepath.Base(binary), "tofu") {
+		return OpenTofuExecutor{Path: binary}
+	}
+
+	return TerraformExecutor{Path: binary}
+}
@@ -0,0 +1,43 @@
+package test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/OctopusDeploy/go-octopusdeploy/v2/pkg/client"
+	"github.com/OctopusSolutionsEngineering/OctopusTerraformTestFramework/v2/octoclient"
+	"github.com/OctopusSolutionsEngineering/OctopusTerraformTestFramework/v2/octofake"
+)
+
+// ArrangeFakeTest runs a test against an in-process fake Octopus server. The fake server does not require
+// Docker or a license, so these tests also run with "go test -short". See the octofake package for the
+// subset of the API that is supported.
+func (o *OctopusContainerTest) ArrangeFakeTest(t *testing.T, testFunc func(t *testing.T, server OctopusInstance, client *client.Client) error) {
+	o.ArrangeFakeTestContext(TestContext(t), t, func(ctx context.Context, t *testing.T, server OctopusInstance, client *client.Client) error {
+		return testFunc(t, server, client)
+	})
+}
+
+// ArrangeFakeTestContext runs a test against an in-process fake Octopus server, passing the context to the test function
+func (o *OctopusContainerTest) ArrangeFakeTestContext(ctx context.Context, t *testing.T, testFunc func(ctx context.Context, t *testing.T, server OctopusInstance, client *client.Client) error) {
+	o.registerSecrets()
+
+	server := octofake.NewServer(o.GetApiKey())
+	defer server.Close()
+
+	client, err := octoclient.CreateClient(server.GetURI(), "", o.GetApiKey())
+	if err != nil {
+		fatalRedacted(t, err.Error())
+	}
+
+	o.setTestInstance(t, server, nil)
+
+	// Destroy any modules while the server is still available
+	err = o.runTestFunc(ctx, t, func() error {
+		return testFunc(ctx, t, server, client)
+	})
+
+	if err != nil {
+		fatalRedacted(t, err.Error())
+	}
+}
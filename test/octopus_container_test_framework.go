@@ -8,7 +8,6 @@ import (
 	"fmt"
 	"io"
 	"log"
-	"net/http"
 	"os"
 	"os/exec"
 	"path/filepath"
@@ -23,10 +22,8 @@ import (
 	lintwait "github.com/OctopusSolutionsEngineering/OctopusTerraformTestFramework/v2/wait"
 	"github.com/avast/retry-go/v4"
 	"github.com/google/uuid"
-	cp "github.com/otiai10/copy"
 	"github.com/testcontainers/testcontainers-go"
 	"github.com/testcontainers/testcontainers-go/wait"
-	orderedmap "github.com/wk8/go-ordered-map/v2"
 )
 
 /*
@@ -36,6 +33,12 @@ import (
 
 const ApiKey = "API-ABCDEFGHIJKLMNOPQURTUVWXYZ12345"
 
+// defaultPassword is the password of the MSSQL sa user and the Octopus admin user
+const defaultPassword = "Password01!"
+
+// InitializationSettings are the variables passed to a module applied by InitialiseOctopus
+//
+// Deprecated: use PipelineStage with RunPipeline instead.
 type InitializationSettings struct {
 	InputVars        []string
 	SpaceIdOutputVar string
@@ -43,15 +46,21 @@ type InitializationSettings struct {
 
 type OctopusContainer struct {
 	testcontainers.Container
-	URI string
+	URI         string
+	apiKey      string
+	logConsumer *ContainerLogConsumer
 }
 
 type MysqlContainer struct {
 	testcontainers.Container
-	port string
-	ip   string
+	port        string
+	ip          string
+	logConsumer *ContainerLogConsumer
 }
 
+// TestLogConsumer prints container logs to stdout.
+//
+// Deprecated: container logs are routed to the test using the container by ContainerLogConsumer.
 type TestLogConsumer struct {
 }
 
@@ -61,19 +70,110 @@ func (g *TestLogConsumer) Accept(l testcontainers.Log) {
 
 var globalMutex = sync.Mutex{}
 
+// OctopusContainerTest configures and runs the test framework. Every setting is optional, and settings
+// left as their zero value fall back to the matching environment variable, and then to a built-in default.
+// This allows different test packages in a single "go test ./..." run to use different settings. Boolean settings
+// are pointers, so a setting of false, created with Bool(false), overrides an environment variable set to true.
 type OctopusContainerTest struct {
 	CustomEnvironment map[string]string
+	// OctopusImageUrl is the Docker image used for the Octopus container. Defaults to OCTOTESTIMAGEURL.
+	OctopusImageUrl string
+	// OctopusVersion is the tag of the Octopus Docker image. Defaults to OCTOTESTVERSION.
+	OctopusVersion string
+	// MSSQLTag is the tag of the MSSQL Docker image. Defaults to OCTO_MSSQLTAG.
+	MSSQLTag string
+	// RetryCount is the number of times a test is attempted. Defaults to OCTOTESTRETRYCOUNT.
+	RetryCount uint
+	// ApiKey is the API key assigned to the admin user. Defaults to OCTOTESTAPIKEY.
+	ApiKey string
+	// License is the base 64 encoded Octopus license. Defaults to LICENSE.
+	License string
+	// EnableDind enables Docker in Docker in the Octopus container. Defaults to OCTODISABLEDIND being set to N.
+	EnableDind *bool
+	// DisableOctopusContainerLogging hides the Octopus container logs. Defaults to OCTODISABLEOCTOCONTAINERLOGGING.
+	//
+	// Deprecated: set OctopusContainerLogLevel to LogLevelNone instead.
+	DisableOctopusContainerLogging *bool
+	// DisableMSSQLContainerLogging hides the MSSQL container logs. Defaults to OCTODISABLEMSSQLCONTAINERLOGGING.
+	//
+	// Deprecated: set MSSQLContainerLogLevel to LogLevelNone instead.
+	DisableMSSQLContainerLogging *bool
+	// OctopusContainerLogLevel is the minimum level of the Octopus container log lines displayed by each test.
+	// Defaults to OCTOTESTOCTOPUSLOGLEVEL, and then to LogLevelInfo.
+	OctopusContainerLogLevel LogLevel
+	// MSSQLContainerLogLevel is the minimum level of the MSSQL container log lines displayed by each test.
+	// Defaults to OCTOTESTMSSQLLOGLEVEL, and then to LogLevelInfo.
+	MSSQLContainerLogLevel LogLevel
+	// ContainerLogDir is a directory that container logs are written to, with a file for each test, instead of
+	// the test output. Defaults to OCTOTESTCONTAINERLOGDIR.
+	ContainerLogDir string
+	// SkipWaitForApi skips the API check between creating a space and populating it. Defaults to OCTOTESTWAITFORAPI being set to false.
+	SkipWaitForApi *bool
+	// SkipInit skips "terraform init". Defaults to OCTOTESTSKIPINIT.
+	SkipInit *bool
+	// DumpState dumps the Terraform state if an output variable can not be read. Defaults to OCTOTESTDUMPSTATE.
+	DumpState *bool
+	// DefaultSpaceId is the space ID used when the ID of a new space can not be read. Defaults to OCTOTESTDEFAULTSPACEID.
+	DefaultSpaceId string
+	// CheckForDrift runs "terraform plan" after every apply and fails if the plan is not empty. Defaults to OCTOTESTCHECKDRIFT.
+	CheckForDrift *bool
+	// DestroyAfterTest destroys the modules applied by the Act functions once the test function completes,
+	// and verifies the space was deleted or left empty. Defaults to OCTOTESTDESTROY.
+	DestroyAfterTest *bool
+	// Executor is the tool used to apply modules. Defaults to terraform, or the executable defined in OCTOTESTIACBINARY.
+	Executor IacExecutor
+	// UseDatabaseSnapshot snapshots the database once the first Octopus server has started, and restores the snapshot
+	// for every subsequent stack with the same settings. Defaults to OCTOTESTDBSNAPSHOT.
+	UseDatabaseSnapshot *bool
+	// VariableNames are the names of the Terraform variables receiving the server details, and of the output holding
+	// the ID of a new space. Defaults to OCTOTESTVARNAMES, and then to octopus_server, octopus_apikey, octopus_space_id,
+	// octopus_space_name, and octopus_space_description.
+	VariableNames TerraformVariableNames
+	// ServerUrl is the URL of an existing Octopus server used by ArrangeInstanceTest instead of a container. Defaults to OCTOTESTSERVERURL.
+	ServerUrl string
+	// UseFakeServer runs ArrangeInstanceTest against the fake server in the octofake package. Defaults to OCTOTESTFAKESERVER.
+	UseFakeServer *bool
+	// LocalProviderDir is a directory holding a locally built provider binary. When set, a CLI configuration file with
+	// dev_overrides for the provider is generated for each test. Defaults to OCTOTESTPROVIDERDIR.
+	LocalProviderDir string
+	// LocalProviderSource is the source address of the provider replaced by LocalProviderDir. Defaults to octopusdeploylabs/octopusdeploy.
+	LocalProviderSource string
+	// PluginCacheDir is the provider plugin cache shared by every test. Defaults to OCTOTESTPLUGINCACHEDIR, then
+	// TF_PLUGIN_CACHE_DIR, then a directory in the user cache directory.
+	PluginCacheDir string
+	// DisablePluginCache disables the provider plugin cache. Defaults to OCTOTESTDISABLEPLUGINCACHE.
+	DisablePluginCache *bool
+	// ProviderMirrorDir is a filesystem mirror that providers are installed from instead of the registry, allowing
+	// tests to run without network access. Defaults to OCTOTESTPROVIDERMIRROR.
+	ProviderMirrorDir string
+	// MaxConcurrentStacks is the number of stacks created by ArrangeTest or the stack pool that can run at once. Tests calling
+	// t.Parallel() wait for a running stack to be removed once the limit is reached. Defaults to OCTOTESTMAXSTACKS,
+	// and then to one stack for every 4GB of available memory.
+	MaxConcurrentStacks int
+	// ArtifactsDir is the directory that failure artifacts are written to. Defaults to OCTOTESTARTIFACTSDIR,
+	// and then to the octoterra-artifacts directory in the temporary directory.
+	ArtifactsDir string
+	// StackPoolSize is the number of stacks kept running by StartStackPool. Defaults to OCTOTESTPOOLSIZE, and then
+	// to the number of stacks that can run at once.
+	StackPoolSize int
+	// StackPoolMaxLifetime is the time a pooled stack is used for before it is replaced. Defaults to
+	// OCTOTESTPOOLMAXLIFETIME, and then to one hour.
+	StackPoolMaxLifetime time.Duration
 }
 
-func (o *OctopusContainerTest) enableContainerLogging(container testcontainers.Container, ctx context.Context) error {
-	// Display the container logs
-	err := container.StartLogProducer(ctx)
-	if err != nil {
-		return err
+// Bool returns a pointer to the value, for use in the boolean settings of OctopusContainerTest
+func Bool(value bool) *bool {
+	return &value
+}
+
+// boolSetting returns the value of a boolean setting if it was set, and otherwise whether the environment variable
+// holds the value that enables the setting
+func boolSetting(setting *bool, envVar string, enabledValue string) bool {
+	if setting != nil {
+		return *setting
 	}
-	g := TestLogConsumer{}
-	container.FollowOutput(&g)
-	return nil
+
+	return strings.EqualFold(os.Getenv(envVar), enabledValue)
 }
 
 // getProvider returns the test containers provider
@@ -89,6 +189,9 @@ func (o *OctopusContainerTest) getProvider() testcontainers.ProviderType {
 func (o *OctopusContainerTest) setupNetwork(ctx context.Context) (testcontainers.Network, string, error) {
 	name := "octotera" + uuid.New().String()
 
+	globalMutex.Lock()
+	defer globalMutex.Unlock()
+
 	network, err := testcontainers.GenericNetwork(ctx, testcontainers.GenericNetworkRequest{
 		NetworkRequest: testcontainers.NetworkRequest{
 			Name: name,
@@ -102,6 +205,22 @@ func (o *OctopusContainerTest) setupNetwork(ctx context.Context) (testcontainers
 	return network, name, err
 }
 
+// createAndStartContainer creates a container, and then starts it and waits for it to be ready. Only creating the
+// container holds globalMutex, so the stacks created by parallel tests and the stack pool boot concurrently.
+func createAndStartContainer(ctx context.Context, req testcontainers.GenericContainerRequest) (testcontainers.Container, error) {
+	req.Started = false
+
+	globalMutex.Lock()
+	container, err := testcontainers.GenericContainer(ctx, req)
+	globalMutex.Unlock()
+
+	if err != nil {
+		return container, err
+	}
+
+	return container, container.Start(ctx)
+}
+
 // setupDatabase creates a MSSQL container
 func (o *OctopusContainerTest) setupDatabase(ctx context.Context, network string) (*MysqlContainer, error) {
 	req := testcontainers.ContainerRequest{
@@ -111,9 +230,9 @@ func (o *OctopusContainerTest) setupDatabase(ctx context.Context, network string
 		ImagePlatform: "linux/amd64",
 		Env: map[string]string{
 			"ACCEPT_EULA": "Y",
-			"SA_PASSWORD": "Password01!",
+			"SA_PASSWORD": defaultPassword,
 		},
-		WaitingFor: wait.ForExec([]string{"/opt/mssql-tools18/bin/sqlcmd", "-C", "-U", "sa", "-P", "Password01!", "-Q", "select 1"}).WithStartupTimeout(60 * time.Second).WithExitCodeMatcher(
+		WaitingFor: wait.ForExec([]string{"/opt/mssql-tools18/bin/sqlcmd", "-C", "-U", "sa", "-P", defaultPassword, "-Q", "select 1"}).WithStartupTimeout(60 * time.Second).WithExitCodeMatcher(
 			func(exitCode int) bool {
 				return exitCode == 0
 			}),
@@ -121,25 +240,22 @@ func (o *OctopusContainerTest) setupDatabase(ctx context.Context, network string
 			network,
 		},
 	}
-	container, err := testcontainers.GenericContainer(ctx, testcontainers.GenericContainerRequest{
+	container, err := createAndStartContainer(ctx, testcontainers.GenericContainerRequest{
 		ContainerRequest: req,
-		Started:          true,
 		Reuse:            false,
+		Logger:           redactingLogger{},
 	})
 
-	// Display the container logs
-	if os.Getenv("OCTODISABLEMSSQLCONTAINERLOGGING") != "true" {
-		o.enableContainerLogging(container, ctx)
-	}
-
-	if err != nil {
+	if err != nil && container != nil {
 		logs, logErr := container.Logs(ctx)
 		if logErr == nil {
 			b, readErr := io.ReadAll(logs)
 			if readErr == nil {
-				log.Println(string(b))
+				log.Println(Redact(string(b)))
 			}
 		}
+	}
+	if err != nil {
 		return nil, err
 	}
 
@@ -154,13 +270,18 @@ func (o *OctopusContainerTest) setupDatabase(ctx context.Context, network string
 	}
 
 	return &MysqlContainer{
-		Container: container,
-		ip:        ip,
-		port:      mappedPort.Port(),
+		Container:   container,
+		logConsumer: o.enableContainerLogging(container, ctx, "mssql", o.getMSSQLContainerLogLevel()),
+		ip:          ip,
+		port:        mappedPort.Port(),
 	}, nil
 }
 
 func (o *OctopusContainerTest) getMSSQLTaggedVersion() string {
+	if o.MSSQLTag != "" {
+		return ":" + o.MSSQLTag
+	}
+
 	overrideMSSQLOctoTag := os.Getenv("OCTO_MSSQLTAG")
 	if overrideMSSQLOctoTag != "" {
 		return ":" + overrideMSSQLOctoTag
@@ -170,6 +291,10 @@ func (o *OctopusContainerTest) getMSSQLTaggedVersion() string {
 }
 
 func (o *OctopusContainerTest) getOctopusImageUrl() string {
+	if o.OctopusImageUrl != "" {
+		return o.OctopusImageUrl
+	}
+
 	overrideImageUrl := os.Getenv("OCTOTESTIMAGEURL")
 	if overrideImageUrl != "" {
 		return overrideImageUrl
@@ -179,6 +304,10 @@ func (o *OctopusContainerTest) getOctopusImageUrl() string {
 }
 
 func (o *OctopusContainerTest) getOctopusVersion() string {
+	if o.OctopusVersion != "" {
+		return o.OctopusVersion
+	}
+
 	overrideOctoTag := os.Getenv("OCTOTESTVERSION")
 	if overrideOctoTag != "" {
 		return overrideOctoTag
@@ -188,6 +317,10 @@ func (o *OctopusContainerTest) getOctopusVersion() string {
 }
 
 func (o *OctopusContainerTest) getRetryCount() uint {
+	if o.RetryCount > 0 {
+		return o.RetryCount
+	}
+
 	count, err := strconv.Atoi(os.Getenv("OCTOTESTRETRYCOUNT"))
 	if err == nil && count > 0 {
 		return uint(count)
@@ -196,21 +329,83 @@ func (o *OctopusContainerTest) getRetryCount() uint {
 	return 3
 }
 
-// setupOctopus creates an Octopus container
-func (o *OctopusContainerTest) setupOctopus(ctx context.Context, connString string, network string) (*OctopusContainer, error) {
-	if os.Getenv("LICENSE") == "" {
-		return nil, errors.New("the LICENSE environment variable must be set to a base 64 encoded Octopus license key")
+func (o *OctopusContainerTest) getLicense() string {
+	if o.License != "" {
+		return o.License
 	}
 
-	if _, err := b64.StdEncoding.DecodeString(os.Getenv("LICENSE")); err != nil {
-		return nil, errors.New("the LICENSE environment variable must be set to a base 64 encoded Octopus license key")
+	return os.Getenv("LICENSE")
+}
+
+// getDisableDind returns the value of the DISABLE_DIND setting passed to the Octopus container
+func (o *OctopusContainerTest) getDisableDind() string {
+	if o.EnableDind != nil {
+		if *o.EnableDind {
+			return "N"
+		}
+
+		return "Y"
 	}
 
 	disableDind := os.Getenv("OCTODISABLEDIND")
 	if disableDind == "" {
-		disableDind = "Y"
+		return "Y"
+	}
+
+	return disableDind
+}
+
+func (o *OctopusContainerTest) getDisableOctopusContainerLogging() bool {
+	return boolSetting(o.DisableOctopusContainerLogging, "OCTODISABLEOCTOCONTAINERLOGGING", "true")
+}
+
+func (o *OctopusContainerTest) getDisableMSSQLContainerLogging() bool {
+	return boolSetting(o.DisableMSSQLContainerLogging, "OCTODISABLEMSSQLCONTAINERLOGGING", "true")
+}
+
+func (o *OctopusContainerTest) getSkipWaitForApi() bool {
+	return boolSetting(o.SkipWaitForApi, "OCTOTESTWAITFORAPI", "false")
+}
+
+// getSkipInit returns true if "terraform init" is skipped. Init still runs when testing a local provider, as
+// dev_overrides only replaces the provider being developed, and modules, other providers, and working copies must
+// still be initialised.
+func (o *OctopusContainerTest) getSkipInit() bool {
+	return boolSetting(o.SkipInit, "OCTOTESTSKIPINIT", "true")
+}
+
+func (o *OctopusContainerTest) getDumpState() bool {
+	return boolSetting(o.DumpState, "OCTOTESTDUMPSTATE", "true")
+}
+
+func (o *OctopusContainerTest) getCheckForDrift() bool {
+	return boolSetting(o.CheckForDrift, "OCTOTESTCHECKDRIFT", "true")
+}
+
+func (o *OctopusContainerTest) getDefaultSpaceId() string {
+	if o.DefaultSpaceId != "" {
+		return o.DefaultSpaceId
+	}
+
+	return os.Getenv("OCTOTESTDEFAULTSPACEID")
+}
+
+// setupOctopus creates an Octopus container
+func (o *OctopusContainerTest) setupOctopus(ctx context.Context, connString string, network string) (*OctopusContainer, error) {
+	o.registerSecrets()
+
+	license := o.getLicense()
+
+	if license == "" {
+		return nil, errors.New("the License setting or LICENSE environment variable must be set to a base 64 encoded Octopus license key")
+	}
+
+	if _, err := b64.StdEncoding.DecodeString(license); err != nil {
+		return nil, errors.New("the License setting or LICENSE environment variable must be set to a base 64 encoded Octopus license key")
 	}
 
+	disableDind := o.getDisableDind()
+
 	req := testcontainers.ContainerRequest{
 		Name:          "octopus-" + uuid.New().String(),
 		Image:         o.getOctopusImageUrl() + ":" + o.getOctopusVersion(),
@@ -222,12 +417,12 @@ func (o *OctopusContainerTest) setupOctopus(ctx context.Context, connString stri
 			// CONNSTRING, LICENSE_BASE64, and CREATE_DB are used by the octopusdeploy/linux image
 			"CONNSTRING":                    connString,
 			"CREATE_DB":                     "Y",
-			"ADMIN_API_KEY":                 getApiKey(),
+			"ADMIN_API_KEY":                 o.GetApiKey(),
 			"DISABLE_DIND":                  disableDind,
 			"ADMIN_USERNAME":                "admin",
-			"ADMIN_PASSWORD":                "Password01!",
-			"OCTOPUS_SERVER_BASE64_LICENSE": os.Getenv("LICENSE"),
-			"LICENSE_BASE64":                os.Getenv("LICENSE"),
+			"ADMIN_PASSWORD":                defaultPassword,
+			"OCTOPUS_SERVER_BASE64_LICENSE": license,
+			"LICENSE_BASE64":                license,
 			"ENABLE_USAGE":                  "N",
 		},
 		Privileged: disableDind != "Y",
@@ -237,31 +432,33 @@ func (o *OctopusContainerTest) setupOctopus(ctx context.Context, connString stri
 		},
 	}
 
+	// Servers started from a database snapshot must share the master key used to encrypt the snapshot
+	if o.getUseDatabaseSnapshot() {
+		req.Env["MASTER_KEY"] = masterKey()
+	}
+
 	req.Env = o.AddCustomEnvironment(req.Env)
 
 	log.Println("Creating Octopus container")
-	container, err := testcontainers.GenericContainer(ctx, testcontainers.GenericContainerRequest{
+	container, err := createAndStartContainer(ctx, testcontainers.GenericContainerRequest{
 		ContainerRequest: req,
-		Started:          true,
 		Reuse:            false,
+		Logger:           redactingLogger{},
 	})
-	if err != nil {
+	if err != nil && container != nil {
 		logs, logErr := container.Logs(ctx)
 		if logErr == nil {
 			b, readErr := io.ReadAll(logs)
 			if readErr == nil {
-				log.Println(string(b))
+				log.Println(Redact(string(b)))
 			}
 		}
+	}
+	if err != nil {
 		return nil, err
 	}
 	log.Println("Finished creating Octopus container")
 
-	// Display the container logs
-	if os.Getenv("OCTODISABLEOCTOCONTAINERLOGGING") != "true" {
-		o.enableContainerLogging(container, ctx)
-	}
-
 	ip, err := container.Host(ctx)
 	if err != nil {
 		return nil, err
@@ -274,10 +471,20 @@ func (o *OctopusContainerTest) setupOctopus(ctx context.Context, connString stri
 
 	uri := fmt.Sprintf("http://%s:%s", ip, mappedPort.Port())
 
-	return &OctopusContainer{Container: container, URI: uri}, nil
+	return &OctopusContainer{
+		Container:   container,
+		URI:         uri,
+		apiKey:      o.GetApiKey(),
+		logConsumer: o.enableContainerLogging(container, ctx, "octopus", o.getOctopusContainerLogLevel()),
+	}, nil
 }
 
-func getApiKey() string {
+// GetApiKey returns the API key used to access the Octopus server
+func (o *OctopusContainerTest) GetApiKey() string {
+	if o.ApiKey != "" {
+		return o.ApiKey
+	}
+
 	apiKey := os.Getenv("OCTOTESTAPIKEY")
 	if apiKey == "" {
 		return ApiKey
@@ -298,7 +505,7 @@ func (o *OctopusContainerTest) AddCustomEnvironment(input map[string]string) map
 		//checking against the input, as `result` is growing on each iteration
 		value, exists := input[k]
 		if exists {
-			log.Println(k + " already exists in OctopusServer's environment as '" + value + "', and will not be replaced")
+			log.Println(k + " already exists in OctopusServer's environment as '" + Redact(value) + "', and will not be replaced")
 		} else {
 			result[k] = v
 		}
@@ -309,19 +516,29 @@ func (o *OctopusContainerTest) AddCustomEnvironment(input map[string]string) map
 
 // createDockerInfrastructure attempts to create the complete Docker stack containing a
 // network, MSSQL container, and Octopus container. The return values include as much of
-// the partial stack as possible in the case of an error.
-func (o *OctopusContainerTest) createDockerInfrastructure(t *testing.T, ctx context.Context) (testcontainers.Network, *OctopusContainer, *MysqlContainer, error) {
+// the partial stack as possible in the case of an error. Progress is reported to the logger,
+// which writes to the test output for stacks created for a single test.
+func (o *OctopusContainerTest) createDockerInfrastructure(logger func(args ...any), ctx context.Context) (testcontainers.Network, *OctopusContainer, *MysqlContainer, error) {
 
 	network, networkName, err := o.setupNetwork(ctx)
 	if err != nil {
-		return nil, nil, nil, err
+		return nil, nil, nil, phaseError(ctx, "creating the network", err)
 	}
 
 	sqlServer, err := o.setupDatabase(ctx, networkName)
+	if err != nil {
+		return network, nil, sqlServer, phaseError(ctx, "starting the MSSQL container", err)
+	}
+
+	restored, err := o.restoreDatabaseSnapshot(ctx, sqlServer)
 	if err != nil {
 		return network, nil, sqlServer, err
 	}
 
+	if restored {
+		logger("Restored the database snapshot")
+	}
+
 	sqlIp, err := sqlServer.Container.ContainerIP(ctx)
 	if err != nil {
 		return network, nil, sqlServer, err
@@ -332,12 +549,12 @@ func (o *OctopusContainerTest) createDockerInfrastructure(t *testing.T, ctx cont
 		return network, nil, sqlServer, err
 	}
 
-	t.Log("SQL Server IP: " + sqlIp)
-	t.Log("SQL Server Container Name: " + sqlName)
+	logger("SQL Server IP: " + sqlIp)
+	logger("SQL Server Container Name: " + sqlName)
 
-	octopusContainer, err := o.setupOctopus(ctx, "Server="+sqlIp+",1433;Database=OctopusDeploy;User=sa;Password=Password01!", networkName)
+	octopusContainer, err := o.setupOctopus(ctx, "Server="+sqlIp+",1433;Database=OctopusDeploy;User=sa;Password="+defaultPassword, networkName)
 	if err != nil {
-		return network, octopusContainer, sqlServer, err
+		return network, octopusContainer, sqlServer, phaseError(ctx, "starting the Octopus container", err)
 	}
 
 	octoIp, err := octopusContainer.Container.ContainerIP(ctx)
@@ -350,14 +567,20 @@ func (o *OctopusContainerTest) createDockerInfrastructure(t *testing.T, ctx cont
 		return network, octopusContainer, sqlServer, err
 	}
 
-	t.Log("Octopus IP: " + octoIp)
-	t.Log("Octopus Container Name: " + octoName)
+	logger("Octopus IP: " + octoIp)
+	logger("Octopus Container Name: " + octoName)
 
 	return network, octopusContainer, sqlServer, nil
 }
 
 // ArrangeContainer is wrapper that initialises Octopus, and returns the container for future test runs
 func (o *OctopusContainerTest) ArrangeContainer() (*OctopusContainer, *client.Client, *MysqlContainer, testcontainers.Network, error) {
+	return o.ArrangeContainerContext(context.Background())
+}
+
+// ArrangeContainerContext is wrapper that initialises Octopus, and returns the container for future test runs.
+// Creating the containers is abandoned if the context is cancelled.
+func (o *OctopusContainerTest) ArrangeContainerContext(ctx context.Context) (*OctopusContainer, *client.Client, *MysqlContainer, testcontainers.Network, error) {
 	var octopusContainer *OctopusContainer
 	var octoClient *client.Client
 	var network testcontainers.Network
@@ -366,19 +589,22 @@ func (o *OctopusContainerTest) ArrangeContainer() (*OctopusContainer, *client.Cl
 
 	err := retry.Do(
 		func() error {
-			ctx := context.Background()
-
 			var err error
 			log.Print("Setting up network")
 			network, networkName, err = o.setupNetwork(ctx)
 			if err != nil {
 				log.Print("Failed to setup network container")
-				return err
+				return phaseError(ctx, "creating the network", err)
 			}
 
 			sqlServer, err = o.setupDatabase(ctx, networkName)
 			if err != nil {
 				log.Print("Failed to setup mssql database container")
+				return phaseError(ctx, "starting the MSSQL container", err)
+			}
+
+			if _, err := o.restoreDatabaseSnapshot(ctx, sqlServer); err != nil {
+				log.Print("Failed to restore the database snapshot")
 				return err
 			}
 
@@ -397,10 +623,10 @@ func (o *OctopusContainerTest) ArrangeContainer() (*OctopusContainer, *client.Cl
 			log.Println("SQL Server IP: " + sqlIp)
 			log.Println("SQL Server Container Name: " + sqlName)
 
-			octopusContainer, err = o.setupOctopus(ctx, "Server="+sqlIp+",1433;Database=OctopusDeploy;User=sa;Password=Password01!", networkName)
+			octopusContainer, err = o.setupOctopus(ctx, "Server="+sqlIp+",1433;Database=OctopusDeploy;User=sa;Password="+defaultPassword, networkName)
 			if err != nil {
 				log.Print("Failed to setup octopus container")
-				return err
+				return phaseError(ctx, "starting the Octopus container", err)
 			}
 
 			octoIp, err := octopusContainer.Container.ContainerIP(ctx)
@@ -419,19 +645,18 @@ func (o *OctopusContainerTest) ArrangeContainer() (*OctopusContainer, *client.Cl
 			log.Println("Octopus Container Name: " + octoName)
 
 			// give the server 5 minutes to start up
-			err = lintwait.WaitForResource(func() error {
-				resp, err := http.Get(octopusContainer.URI + "/api")
-				if err != nil || resp.StatusCode != http.StatusOK {
-					return errors.New("the api endpoint was not available")
-				}
-				return nil
-			}, 5*time.Minute)
+			err = waitForApi(ctx, octopusContainer.URI)
 
 			if err != nil {
 				return err
 			}
 
-			octoClient, err = octoclient.CreateClient(octopusContainer.URI, "", getApiKey())
+			// A failed snapshot only means the next stack starts from an empty database
+			if err := o.takeDatabaseSnapshot(ctx, sqlServer); err != nil {
+				log.Println("Failed to take a snapshot of the database: " + err.Error())
+			}
+
+			octoClient, err = octoclient.CreateClient(octopusContainer.URI, "", o.GetApiKey())
 			if err != nil {
 				return err
 			}
@@ -440,6 +665,7 @@ func (o *OctopusContainerTest) ArrangeContainer() (*OctopusContainer, *client.Cl
 		},
 		retry.Attempts(o.getRetryCount()),
 		retry.Delay(30*time.Second),
+		retry.Context(ctx),
 	)
 
 	if err != nil {
@@ -478,8 +704,33 @@ func (o *OctopusContainerTest) CleanUp(ctx context.Context, octoContainer *Octop
 	return nil
 }
 
-// ArrangeTest is wrapper that initialises Octopus, runs a test, and cleans up the containers
+// ArrangeTest is wrapper that initialises Octopus, runs a test, and cleans up the containers.
+// If a shared stack was started with StartSharedStack, the test is run against the shared stack instead,
+// and if a stack pool was started with StartStackPool, the test is run against a stack leased from the pool.
 func (o *OctopusContainerTest) ArrangeTest(t *testing.T, testFunc func(t *testing.T, container *OctopusContainer, client *client.Client) error) {
+	o.ArrangeTestContext(TestContext(t), t, func(ctx context.Context, t *testing.T, container *OctopusContainer, client *client.Client) error {
+		return testFunc(t, container, client)
+	})
+}
+
+// ArrangeTestContext is wrapper that initialises Octopus, runs a test, and cleans up the containers.
+// The test fails with a TimeoutError naming the phase that hung if the context is cancelled.
+func (o *OctopusContainerTest) ArrangeTestContext(ctx context.Context, t *testing.T, testFunc func(ctx context.Context, t *testing.T, container *OctopusContainer, client *client.Client) error) {
+	if stack := getSharedStack(); stack != nil {
+		o.arrangeSharedTest(ctx, t, stack, testFunc)
+		return
+	}
+
+	if pool := getStackPool(); pool != nil {
+		o.arrangePooledTest(ctx, t, pool, testFunc)
+		return
+	}
+
+	o.arrangeNewStackTest(ctx, t, testFunc)
+}
+
+// arrangeNewStackTest creates a stack for the test, runs the test, and removes the stack
+func (o *OctopusContainerTest) arrangeNewStackTest(ctx context.Context, t *testing.T, testFunc func(ctx context.Context, t *testing.T, container *OctopusContainer, client *client.Client) error) {
 	err := retry.Do(
 		func() error {
 
@@ -487,14 +738,21 @@ func (o *OctopusContainerTest) ArrangeTest(t *testing.T, testFunc func(t *testin
 				t.Skip("skipping integration test")
 			}
 
-			ctx := context.Background()
+			// Tests calling t.Parallel() each create a stack, so limit how many run at once.
+			// The stack is released once it has been cleaned up.
+			if err := o.acquireStack(ctx); err != nil {
+				return err
+			}
+			defer o.releaseStack()
 
 			// I don't think test containers are thread safe - parallel tests
 			// frequently show that multiple tests access the same containers.
-			// So only one thread can create a stack at a time
-			globalMutex.Lock()
-			network, octopusContainer, sqlServer, err := o.createDockerInfrastructure(t, ctx)
-			globalMutex.Unlock()
+			// So only one thread can create or remove containers at a time. Each stack
+			// has uniquely named containers and network, and the containers boot and
+			// the test functions run concurrently.
+			network, octopusContainer, sqlServer, err := o.createDockerInfrastructure(func(args ...any) {
+				logRedacted(t, args...)
+			}, ctx)
 
 			// Attempt to clean up whatever resources were created.
 			// Don't return errors for the cleanup, just report them
@@ -502,17 +760,20 @@ func (o *OctopusContainerTest) ArrangeTest(t *testing.T, testFunc func(t *testin
 				globalMutex.Lock()
 				defer globalMutex.Unlock()
 
+				// The containers must be cleaned up even if the test context was cancelled
+				ctx := context.WithoutCancel(ctx)
+
 				stopTime := 1 * time.Minute
 
 				if octopusContainer != nil {
 					// This fixes the "can not get logs from container which is dead or marked for removal" error
 					// See https://github.com/testcontainers/testcontainers-go/issues/606
-					if os.Getenv("OCTODISABLEOCTOCONTAINERLOGGING") != "true" {
+					if octopusContainer.logConsumer != nil {
 						stopProducerErr := octopusContainer.StopLogProducer()
 
 						// try to continue on if there was an error stopping the producer
 						if stopProducerErr != nil {
-							t.Log(stopProducerErr)
+							logRedacted(t, stopProducerErr)
 						}
 					}
 
@@ -520,13 +781,13 @@ func (o *OctopusContainerTest) ArrangeTest(t *testing.T, testFunc func(t *testin
 					octoStopErr := octopusContainer.Stop(ctx, &stopTime)
 
 					if octoStopErr != nil {
-						t.Log("Failed to stop the Octopus container")
+						logRedacted(t, "Failed to stop the Octopus container")
 					}
 
 					octoTerminateErr := octopusContainer.Terminate(ctx)
 
 					if octoTerminateErr != nil {
-						t.Log("Failed to terminate the Octopus container")
+						logRedacted(t, "Failed to terminate the Octopus container")
 					}
 				}
 
@@ -534,13 +795,13 @@ func (o *OctopusContainerTest) ArrangeTest(t *testing.T, testFunc func(t *testin
 					sqlStopErr := sqlServer.Stop(ctx, &stopTime)
 
 					if sqlStopErr != nil {
-						t.Log("Failed to stop the MSSQL container")
+						logRedacted(t, "Failed to stop the MSSQL container")
 					}
 
 					sqlTerminateErr := sqlServer.Terminate(ctx)
 
 					if sqlTerminateErr != nil {
-						t.Log("Failed to terminate the MSSQL container")
+						logRedacted(t, "Failed to terminate the MSSQL container")
 					}
 				}
 
@@ -549,7 +810,7 @@ func (o *OctopusContainerTest) ArrangeTest(t *testing.T, testFunc func(t *testin
 					networkErr := network.Remove(ctx)
 
 					if networkErr != nil {
-						t.Logf("failed to remove network: %v", networkErr)
+						logRedactedf(t, "failed to remove network: %v", networkErr)
 					}
 				}
 			}()
@@ -560,38 +821,46 @@ func (o *OctopusContainerTest) ArrangeTest(t *testing.T, testFunc func(t *testin
 				return err
 			}
 
+			o.setTestInstance(t, octopusContainer, sqlServer)
+			routeContainerLogs(t, octopusContainer, sqlServer)
+
 			// give the server 5 minutes to start up
-			err = lintwait.WaitForResource(func() error {
-				resp, err := http.Get(octopusContainer.URI + "/api")
-				if err != nil || resp.StatusCode != http.StatusOK {
-					return errors.New("the api endpoint was not available")
-				}
-				return nil
-			}, 5*time.Minute)
+			err = waitForApi(ctx, octopusContainer.URI)
 
 			if err != nil {
+				o.writeFailureArtifacts(ctx, t)
 				return err
 			}
 
-			client, err := octoclient.CreateClient(octopusContainer.URI, "", getApiKey())
+			// A failed snapshot only means the next stack starts from an empty database
+			if err := o.takeDatabaseSnapshot(ctx, sqlServer); err != nil {
+				logRedacted(t, "Failed to take a snapshot of the database: "+err.Error())
+			}
+
+			client, err := octoclient.CreateClient(octopusContainer.URI, "", o.GetApiKey())
 			if err != nil {
 				return err
 			}
 
-			err = testFunc(t, octopusContainer, client)
+			// Destroy any modules while the containers are still available
+			err = o.runTestFunc(ctx, t, func() error {
+				return testFunc(ctx, t, octopusContainer, client)
+			})
 
 			if err != nil {
-				t.Log(err.Error())
+				logRedacted(t, err.Error())
 			}
 
 			return err
 		},
 		retry.Attempts(o.getRetryCount()),
 		retry.Delay(30*time.Second),
+		retry.Context(ctx),
+		retry.RetryIf(isRetryable),
 	)
 
 	if err != nil {
-		t.Fatal(err.Error())
+		fatalRedacted(t, err.Error())
 	}
 }
 
@@ -629,66 +898,137 @@ func (o *OctopusContainerTest) deleteIfExists(file string) error {
 	return err
 }
 
+// terraformCommand builds a command for the configured executor that is interrupted when the context is cancelled
+func (o *OctopusContainerTest) terraformCommand(ctx context.Context, t *testing.T, terraformProjectDir string, args ...string) (*exec.Cmd, error) {
+	o.registerSecrets()
+
+	cliEnvironment, err := o.cliEnvironment(t)
+	if err != nil {
+		return nil, err
+	}
+
+	executor := o.getExecutor()
+	cmnd := exec.CommandContext(ctx, executor.Binary(), args...)
+	cmnd.Dir = o.getWorkingCopy(t, terraformProjectDir)
+	cmnd.Env = append(append(os.Environ(), executor.Environment()...), cliEnvironment...)
+	interruptOnCancel(cmnd)
+	return cmnd, nil
+}
+
 // TerraformInit runs "terraform init"
 func (o *OctopusContainerTest) TerraformInit(t *testing.T, terraformProjectDir string) error {
+	return o.TerraformInitContext(TestContext(t), t, terraformProjectDir)
+}
+
+// TerraformInitContext runs "terraform init", interrupting terraform if the context is cancelled
+func (o *OctopusContainerTest) TerraformInitContext(ctx context.Context, t *testing.T, terraformProjectDir string) error {
+	return o.lockPluginCache(t, terraformProjectDir, func() error {
+		return o.terraformInit(ctx, t, terraformProjectDir)
+	})
+}
+
+// terraformInit runs "terraform init" without holding the plugin cache lock
+func (o *OctopusContainerTest) terraformInit(ctx context.Context, t *testing.T, terraformProjectDir string) error {
 	args := []string{"init", "-no-color"}
-	cmnd := exec.Command("terraform", args...)
-	cmnd.Dir = terraformProjectDir
-	out, err := cmnd.Output()
+	cmnd, err := o.terraformCommand(ctx, t, terraformProjectDir, args...)
+	if err != nil {
+		return err
+	}
 
-	t.Log(string(out))
+	out, err := o.commandOutput(t, cmnd)
+
+	logRedacted(t, string(out))
 
 	if err != nil {
 		exitError, ok := err.(*exec.ExitError)
 		if ok {
-			t.Log("terraform init error: " + string(exitError.Stderr))
+			logRedacted(t, "terraform init error: "+string(exitError.Stderr))
 		} else {
-			t.Log(err.Error())
+			logRedacted(t, err.Error())
 		}
 
-		return err
+		return phaseError(ctx, "running terraform init in "+terraformProjectDir, err)
 	}
 
 	return nil
 }
 
+// terraformVarArgs returns the arguments defining the variables passed to the plan and apply commands, and the
+// environment variables passed to the provider. The server details are passed in a variable file, so the API key
+// does not appear in the process list.
+func (o *OctopusContainerTest) terraformVarArgs(t *testing.T, server string, spaceId string, vars []string) ([]string, []string, error) {
+	names := o.getVariableNames()
+
+	serverVars, err := o.TerraformVars(t, names.serverVariables(server, o.GetApiKey(), spaceId))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return append(serverVars, vars...), names.providerEnvironment(server, o.GetApiKey()), nil
+}
+
 // TerraformApply runs "terraform apply"
 func (o *OctopusContainerTest) TerraformApply(t *testing.T, terraformProjectDir string, server string, spaceId string, vars []string) error {
+	return o.TerraformApplyContext(TestContext(t), t, terraformProjectDir, server, spaceId, vars)
+}
+
+// TerraformApplyContext runs "terraform apply", interrupting terraform if the context is cancelled
+func (o *OctopusContainerTest) TerraformApplyContext(ctx context.Context, t *testing.T, terraformProjectDir string, server string, spaceId string, vars []string) error {
+	varArgs, varEnv, err := o.terraformVarArgs(t, server, spaceId, vars)
+	if err != nil {
+		return err
+	}
+
 	newArgs := append([]string{
 		"apply",
 		"-auto-approve",
 		"-no-color",
-		"-var=octopus_server=" + server,
-		"-var=octopus_apikey=" + getApiKey(),
-		"-var=octopus_space_id=" + spaceId,
-	}, vars...)
+	}, varArgs...)
+
+	registerSensitiveVariables(terraformProjectDir, vars)
 
-	cmnd := exec.Command("terraform", newArgs...)
-	cmnd.Dir = terraformProjectDir
-	out, err := cmnd.Output()
+	cmnd, err := o.terraformCommand(ctx, t, terraformProjectDir, newArgs...)
+	if err != nil {
+		return err
+	}
+	cmnd.Env = append(cmnd.Env, varEnv...)
+
+	out, err := o.commandOutput(t, cmnd)
 
-	t.Log(string(out))
+	logRedacted(t, string(out))
 
 	if err != nil {
-		t.Log("server: " + server)
-		t.Log("spaceId: " + spaceId)
+		logRedacted(t, "server: "+server)
+		logRedacted(t, "spaceId: "+spaceId)
 
 		exitError, ok := err.(*exec.ExitError)
 		if ok {
-			t.Log("terraform apply error")
-			t.Log(string(exitError.Stderr))
+			logRedacted(t, "terraform apply error")
+			logRedacted(t, string(exitError.Stderr))
 		} else {
-			t.Log(err)
+			logRedacted(t, err)
 		}
-		return err
+		return phaseError(ctx, "running terraform apply in "+terraformProjectDir, err)
 	}
 
 	return nil
 }
 
+// waitForApi waits for the Octopus API to respond
+func waitForApi(ctx context.Context, server string) error {
+	err := lintwait.WaitForResourceContext(ctx, func() error {
+		if err := checkApiContext(ctx, server+"/api"); err != nil {
+			return errors.New("the api endpoint was not available")
+		}
+		return nil
+	}, 5*time.Minute)
+
+	return phaseError(ctx, "waiting for the Octopus API", err)
+}
+
 // waitForSpace attempts to ensure the API and space is available before continuing
-func (o *OctopusContainerTest) waitForSpace(t *testing.T, server string, spaceId string) {
-	if os.Getenv("OCTOTESTWAITFORAPI") == "false" {
+func (o *OctopusContainerTest) waitForSpace(ctx context.Context, t *testing.T, server string, spaceId string) {
+	if o.getSkipWaitForApi() {
 		return
 	}
 
@@ -697,58 +1037,61 @@ func (o *OctopusContainerTest) waitForSpace(t *testing.T, server string, spaceId
 	// are sometimes proceeded with:
 	// "HTTP" "GET" to "localhost:32805""/api" "completed" with 503 in 00:00:00.0170358 (17ms) by "<anonymous>"
 	// So wait until we get a valid response from the API endpoint before applying terraform
-	err := lintwait.WaitForResource(func() error {
-		response, err := http.Get(server + "/api")
-		if err != nil {
-			return err
-		}
-		if !(response.StatusCode >= 200 && response.StatusCode <= 299) {
-			return errors.New("non 2xx status code returned")
-		}
-		return nil
+	err := lintwait.WaitForResourceContext(ctx, func() error {
+		return checkApiContext(ctx, server+"/api")
 	}, 5*time.Minute)
 
 	if err != nil {
-		t.Log("Failed to contact Octopus API on " + server + "/api")
+		logRedacted(t, "Failed to contact Octopus API on "+server+"/api")
 	}
 
 	// Also wait for the space to be available
-	err = lintwait.WaitForResource(func() error {
-		response, err := http.Get(server + "/api/" + spaceId)
-		if err != nil {
-			return err
-		}
-		if !(response.StatusCode >= 200 && response.StatusCode <= 299) {
-			return errors.New("non 2xx status code returned")
-		}
-		return nil
+	err = lintwait.WaitForResourceContext(ctx, func() error {
+		return checkApiContext(ctx, server+"/api/"+spaceId)
 	}, 5*time.Minute)
 
 	if err != nil {
-		t.Log("Failed to contact Octopus API on " + server + "/api/" + spaceId)
+		logRedacted(t, "Failed to contact Octopus API on "+server+"/api/"+spaceId)
 	}
 }
 
-// TerraformInitAndApply calls terraform init and apply on the supplied directory.
-func (o *OctopusContainerTest) TerraformInitAndApply(t *testing.T, container *OctopusContainer, terraformProjectDir string, spaceId string, vars []string) error {
+// TerraformInitAndApply calls terraform init and apply on the supplied directory. If CheckForDrift is enabled,
+// terraform plan is run after the apply and a DriftError is returned if the plan is not empty.
+func (o *OctopusContainerTest) TerraformInitAndApply(t *testing.T, container OctopusInstance, terraformProjectDir string, spaceId string, vars []string) error {
+	return o.TerraformInitAndApplyContext(TestContext(t), t, container, terraformProjectDir, spaceId, vars)
+}
+
+// TerraformInitAndApplyContext calls terraform init and apply on the supplied directory, interrupting terraform if the context is cancelled.
+func (o *OctopusContainerTest) TerraformInitAndApplyContext(ctx context.Context, t *testing.T, container OctopusInstance, terraformProjectDir string, spaceId string, vars []string) error {
 	o.cleanTerraformModule(terraformProjectDir)
 
-	if strings.ToLower(os.Getenv("OCTOTESTSKIPINIT")) != "true" {
-		err := o.TerraformInit(t, terraformProjectDir)
+	if !o.getSkipInit() {
+		err := o.TerraformInitContext(ctx, t, terraformProjectDir)
 
 		if err != nil {
 			return err
 		}
 	}
 
-	return o.TerraformApply(t, terraformProjectDir, container.URI, spaceId, vars)
+	err := o.TerraformApplyContext(ctx, t, terraformProjectDir, container.GetURI(), spaceId, vars)
+
+	if err != nil {
+		return err
+	}
+
+	if o.getCheckForDrift() {
+		return o.AssertNoDriftContext(ctx, t, terraformProjectDir, container.GetURI(), spaceId, vars)
+	}
+
+	return nil
 }
 
 // InitialiseOctopus uses Terraform to populate the test Octopus instance, making sure to clean up
 // any files generated during previous Terraform executions to avoid conflicts and locking issues.
+// If CheckForDrift is enabled, every module is checked for drift after it is applied.
 func (o *OctopusContainerTest) InitialiseOctopus(
 	t *testing.T,
-	container *OctopusContainer,
+	container OctopusInstance,
 	terraformInitModuleDir string,
 	prepopulateModuleDir string,
 	terraformModuleDir string,
@@ -756,96 +1099,88 @@ func (o *OctopusContainerTest) InitialiseOctopus(
 	initialiseVars []string,
 	prepopulateVars []string,
 	populateVars []string) error {
+	return o.InitialiseOctopusContext(TestContext(t), t, container, terraformInitModuleDir, prepopulateModuleDir, terraformModuleDir, spaceName, initialiseVars, prepopulateVars, populateVars)
+}
 
-	path, err := os.Getwd()
-	if err != nil {
-		return err
-	}
-	t.Log("Working dir: " + path)
+// InitialiseOctopusContext is the same as InitialiseOctopus, but interrupts terraform if the context is cancelled.
+func (o *OctopusContainerTest) InitialiseOctopusContext(
+	ctx context.Context,
+	t *testing.T,
+	container OctopusInstance,
+	terraformInitModuleDir string,
+	prepopulateModuleDir string,
+	terraformModuleDir string,
+	spaceName string,
+	initialiseVars []string,
+	prepopulateVars []string,
+	populateVars []string) error {
 
-	// This test creates a new space and then populates the space.
-	terraformProjectDirs := orderedmap.New[string, InitializationSettings]()
-	terraformProjectDirs.Set(terraformInitModuleDir, InitializationSettings{
-		InputVars:        append(initialiseVars, "-var=octopus_space_name="+spaceName, "-var=octopus_space_description="+t.Name()),
-		SpaceIdOutputVar: "octopus_space_id",
-	})
+	names := o.getVariableNames()
+
+	// This test creates a new space and then populates the space. Later arguments take precedence, so the
+	// default space name and description are passed first, allowing initialiseVars to override them.
+	stages := []PipelineStage{{
+		ModuleDir:     terraformInitModuleDir,
+		Vars:          append(names.spaceVarArgs(spaceName, t.Name()), initialiseVars...),
+		SpaceIdOutput: names.SpaceIdOutput,
+	}}
 	if prepopulateModuleDir != "" {
-		terraformProjectDirs.Set(prepopulateModuleDir, InitializationSettings{
-			InputVars:        prepopulateVars,
-			SpaceIdOutputVar: "",
+		stages = append(stages, PipelineStage{
+			ModuleDir: prepopulateModuleDir,
+			Vars:      prepopulateVars,
 		})
 	}
-	terraformProjectDirs.Set(terraformModuleDir, InitializationSettings{
-		InputVars:        populateVars,
-		SpaceIdOutputVar: "",
+	stages = append(stages, PipelineStage{
+		ModuleDir: terraformModuleDir,
+		Vars:      populateVars,
 	})
 
-	// First loop initialises the new space, second populates the space
-	spaceId := "Spaces-1"
-	for pair := terraformProjectDirs.Oldest(); pair != nil; pair = pair.Next() {
-		terraformProjectDir := pair.Key
-		settings := pair.Value
-
-		o.cleanTerraformModule(terraformProjectDir)
-
-		if strings.ToLower(os.Getenv("OCTOTESTSKIPINIT")) != "true" {
-			err := o.TerraformInit(t, terraformProjectDir)
-
-			if err != nil {
-				return err
-			}
-		}
+	_, err := o.RunPipelineContext(ctx, t, container, stages)
+	return err
+}
 
-		o.waitForSpace(t, container.URI, spaceId)
+// terraformOutputJson runs "terraform output -json", optionally for a single output
+func (o *OctopusContainerTest) terraformOutputJson(ctx context.Context, t *testing.T, terraformDir string, outputVar ...string) ([]byte, error) {
+	// Note that you "terraform output -raw" can still get a 0 exit code if there was an error:
+	// https://github.com/hashicorp/terraform/issues/32384
+	// So we must get the JSON.
+	cmnd, err := o.terraformCommand(
+		ctx,
+		t,
+		terraformDir,
+		append([]string{"output", "-json"}, outputVar...)...)
+	if err != nil {
+		return nil, err
+	}
 
-		err = o.TerraformApply(t, terraformProjectDir, container.URI, spaceId, settings.InputVars)
+	out, err := o.commandOutput(t, cmnd)
 
-		if err != nil {
-			return err
+	if err != nil {
+		if o.getDumpState() {
+			o.ShowStateContext(ctx, t, terraformDir)
 		}
-
-		// get the ID of any new space created, which will be used in the subsequent Terraform executions
-		if settings.SpaceIdOutputVar != "" {
-			spaceId, err = o.GetOutputVariable(t, terraformProjectDir, settings.SpaceIdOutputVar)
-			if err != nil || len(strings.TrimSpace(spaceId)) == 0 {
-				// I've seen number of tests fail because the state file is blank and there is no output to read.
-				// We offer a workaround for this by setting the default space ID, which is usually Spaces-2
-				if os.Getenv("OCTOTESTDEFAULTSPACEID") != "" {
-					spaceId = os.Getenv("OCTOTESTDEFAULTSPACEID")
-				} else {
-					return err
-				}
-			}
+		exitError, ok := err.(*exec.ExitError)
+		if ok {
+			logRedacted(t, "terraform output error: "+string(exitError.Stderr))
+		} else {
+			logRedacted(t, err)
 		}
+		return nil, phaseError(ctx, "running terraform output in "+terraformDir, err)
 	}
 
-	return nil
+	return out, nil
 }
 
-// GetOutputVariable reads a Terraform output variable
+// GetOutputVariable reads a Terraform output variable. Use GetOutputAs for outputs that are not strings.
 func (o *OctopusContainerTest) GetOutputVariable(t *testing.T, terraformDir string, outputVar string) (string, error) {
+	return o.GetOutputVariableContext(TestContext(t), t, terraformDir, outputVar)
+}
 
-	// Note that you "terraform output -raw" can still get a 0 exit code if there was an error:
-	// https://github.com/hashicorp/terraform/issues/32384
-	// So we must get the JSON.
-	cmnd := exec.Command(
-		"terraform",
-		"output",
-		"-json",
-		outputVar)
-	cmnd.Dir = terraformDir
-	out, err := cmnd.Output()
+// GetOutputVariableContext reads a Terraform output variable, interrupting terraform if the context is cancelled
+func (o *OctopusContainerTest) GetOutputVariableContext(ctx context.Context, t *testing.T, terraformDir string, outputVar string) (string, error) {
+	out, err := o.terraformOutputJson(ctx, t, terraformDir, outputVar)
 
 	if err != nil {
-		if os.Getenv("OCTOTESTDUMPSTATE") == "true" {
-			o.ShowState(t, terraformDir)
-		}
-		exitError, ok := err.(*exec.ExitError)
-		if ok {
-			t.Log("terraform output error: " + string(exitError.Stderr))
-		} else {
-			t.Log(err)
-		}
 		return "", err
 	}
 
@@ -861,24 +1196,34 @@ func (o *OctopusContainerTest) GetOutputVariable(t *testing.T, terraformDir stri
 
 // ShowState reads the terraform state
 func (o *OctopusContainerTest) ShowState(t *testing.T, terraformDir string) error {
-	cmnd := exec.Command(
-		"terraform",
+	return o.ShowStateContext(TestContext(t), t, terraformDir)
+}
+
+// ShowStateContext reads the terraform state, interrupting terraform if the context is cancelled
+func (o *OctopusContainerTest) ShowStateContext(ctx context.Context, t *testing.T, terraformDir string) error {
+	cmnd, err := o.terraformCommand(
+		ctx,
+		t,
+		terraformDir,
 		"show",
 		"-json")
-	cmnd.Dir = terraformDir
-	out, err := cmnd.Output()
+	if err != nil {
+		return err
+	}
+
+	out, err := o.commandOutput(t, cmnd)
 
 	if err != nil {
 		exitError, ok := err.(*exec.ExitError)
 		if ok {
-			t.Log("terraform show return code: " + string(exitError.Stderr))
+			logRedacted(t, "terraform show return code: "+string(exitError.Stderr))
 		} else {
-			t.Log(err)
+			logRedacted(t, err)
 		}
-		return err
+		return phaseError(ctx, "running terraform show in "+terraformDir, err)
 	}
 
-	t.Log(string(out))
+	logRedacted(t, string(out))
 
 	if err != nil {
 		return err
@@ -888,37 +1233,30 @@ func (o *OctopusContainerTest) ShowState(t *testing.T, terraformDir string) erro
 }
 
 // Act initialises Octopus and MSSQL
-func (o *OctopusContainerTest) Act(t *testing.T, container *OctopusContainer, terraformBaseDir string, terraformModuleDir string, populateVars []string) (string, error) {
-	spaceName := strings.ReplaceAll(fmt.Sprint(uuid.New()), "-", "")[:20]
-	t.Log("POPULATING TEST SPACE " + spaceName)
-
-	spacePopulateDir := filepath.Join(terraformBaseDir, "1-singlespace")
-	dir, err := o.copyDir(spacePopulateDir)
+func (o *OctopusContainerTest) Act(t *testing.T, container OctopusInstance, terraformBaseDir string, terraformModuleDir string, populateVars []string) (string, error) {
+	return o.ActContext(TestContext(t), t, container, terraformBaseDir, terraformModuleDir, populateVars)
+}
 
-	if err != nil {
-		return "", err
-	}
+// ActContext initialises Octopus and MSSQL, interrupting terraform if the context is cancelled
+func (o *OctopusContainerTest) ActContext(ctx context.Context, t *testing.T, container OctopusInstance, terraformBaseDir string, terraformModuleDir string, populateVars []string) (string, error) {
+	spaceName := strings.ReplaceAll(fmt.Sprint(uuid.New()), "-", "")[:20]
+	logRedacted(t, "POPULATING TEST SPACE "+spaceName)
 
-	defer func() {
-		err := os.RemoveAll(dir)
-		if err != nil {
-			t.Fatal(err.Error())
-		}
-	}()
+	dir := filepath.Join(terraformBaseDir, "1-singlespace")
 
-	err = o.InitialiseOctopus(t, container, dir, "", filepath.Join(terraformBaseDir, terraformModuleDir), spaceName, []string{}, []string{}, populateVars)
+	err := o.InitialiseOctopusContext(ctx, t, container, dir, "", filepath.Join(terraformBaseDir, terraformModuleDir), spaceName, []string{}, []string{}, populateVars)
 
 	if err != nil {
 		return "", err
 	}
 
-	spaceId, err := o.GetOutputVariable(t, dir, "octopus_space_id")
+	spaceId, err := o.GetOutputVariableContext(ctx, t, dir, o.getVariableNames().SpaceIdOutput)
 
 	if err != nil || len(strings.TrimSpace(spaceId)) == 0 {
 		// I've seen number of tests fail because the state file is blank and there is no output to read.
 		// We offer a workaround for this by setting the default space ID, which is usually Spaces-2
-		if os.Getenv("OCTOTESTDEFAULTSPACEID") != "" {
-			spaceId = os.Getenv("OCTOTESTDEFAULTSPACEID")
+		if o.getDefaultSpaceId() != "" {
+			spaceId = o.getDefaultSpaceId()
 			return spaceId, nil
 		} else {
 			return "", err
@@ -929,23 +1267,28 @@ func (o *OctopusContainerTest) Act(t *testing.T, container *OctopusContainer, te
 }
 
 // ActWithCustomSpace initialises Octopus and MSSQL with a custom directory holding the module to create the initial space
-func (o *OctopusContainerTest) ActWithCustomSpace(t *testing.T, container *OctopusContainer, initialiseModuleDir string, terraformModuleDir string, initialiseVars []string, populateVars []string) (string, error) {
+func (o *OctopusContainerTest) ActWithCustomSpace(t *testing.T, container OctopusInstance, initialiseModuleDir string, terraformModuleDir string, initialiseVars []string, populateVars []string) (string, error) {
+	return o.ActWithCustomSpaceContext(TestContext(t), t, container, initialiseModuleDir, terraformModuleDir, initialiseVars, populateVars)
+}
+
+// ActWithCustomSpaceContext is the same as ActWithCustomSpace, but interrupts terraform if the context is cancelled
+func (o *OctopusContainerTest) ActWithCustomSpaceContext(ctx context.Context, t *testing.T, container OctopusInstance, initialiseModuleDir string, terraformModuleDir string, initialiseVars []string, populateVars []string) (string, error) {
 	spaceName := strings.ReplaceAll(fmt.Sprint(uuid.New()), "-", "")[:20]
-	t.Log("POPULATING TEST SPACE " + spaceName)
+	logRedacted(t, "POPULATING TEST SPACE "+spaceName)
 
-	err := o.InitialiseOctopus(t, container, initialiseModuleDir, "", terraformModuleDir, spaceName, initialiseVars, []string{}, populateVars)
+	err := o.InitialiseOctopusContext(ctx, t, container, initialiseModuleDir, "", terraformModuleDir, spaceName, initialiseVars, []string{}, populateVars)
 
 	if err != nil {
 		return "", err
 	}
 
-	spaceId, err := o.GetOutputVariable(t, initialiseModuleDir, "octopus_space_id")
+	spaceId, err := o.GetOutputVariableContext(ctx, t, initialiseModuleDir, o.getVariableNames().SpaceIdOutput)
 
 	if err != nil || len(strings.TrimSpace(spaceId)) == 0 {
 		// I've seen number of tests fail because the state file is blank and there is no output to read.
 		// We offer a workaround for this by setting the default space ID, which is usually Spaces-2
-		if os.Getenv("OCTOTESTDEFAULTSPACEID") != "" {
-			spaceId = os.Getenv("OCTOTESTDEFAULTSPACEID")
+		if o.getDefaultSpaceId() != "" {
+			spaceId = o.getDefaultSpaceId()
 			return spaceId, nil
 		} else {
 			return "", err
@@ -956,23 +1299,28 @@ func (o *OctopusContainerTest) ActWithCustomSpace(t *testing.T, container *Octop
 }
 
 // ActWithCustomPrePopulatedSpace initialises Octopus and MSSQL with a custom directory holding the module to create the initial space and a module used to prepopulate the space
-func (o *OctopusContainerTest) ActWithCustomPrePopulatedSpace(t *testing.T, container *OctopusContainer, initialiseModuleDir string, prepopulateModuleDir string, terraformModuleDir string, initialiseVars []string, prePopulateVars []string, populateVars []string) (string, error) {
+func (o *OctopusContainerTest) ActWithCustomPrePopulatedSpace(t *testing.T, container OctopusInstance, initialiseModuleDir string, prepopulateModuleDir string, terraformModuleDir string, initialiseVars []string, prePopulateVars []string, populateVars []string) (string, error) {
+	return o.ActWithCustomPrePopulatedSpaceContext(TestContext(t), t, container, initialiseModuleDir, prepopulateModuleDir, terraformModuleDir, initialiseVars, prePopulateVars, populateVars)
+}
+
+// ActWithCustomPrePopulatedSpaceContext is the same as ActWithCustomPrePopulatedSpace, but interrupts terraform if the context is cancelled
+func (o *OctopusContainerTest) ActWithCustomPrePopulatedSpaceContext(ctx context.Context, t *testing.T, container OctopusInstance, initialiseModuleDir string, prepopulateModuleDir string, terraformModuleDir string, initialiseVars []string, prePopulateVars []string, populateVars []string) (string, error) {
 	spaceName := strings.ReplaceAll(fmt.Sprint(uuid.New()), "-", "")[:20]
-	t.Log("POPULATING TEST SPACE " + spaceName)
+	logRedacted(t, "POPULATING TEST SPACE "+spaceName)
 
-	err := o.InitialiseOctopus(t, container, initialiseModuleDir, prepopulateModuleDir, terraformModuleDir, spaceName, initialiseVars, prePopulateVars, populateVars)
+	err := o.InitialiseOctopusContext(ctx, t, container, initialiseModuleDir, prepopulateModuleDir, terraformModuleDir, spaceName, initialiseVars, prePopulateVars, populateVars)
 
 	if err != nil {
 		return "", err
 	}
 
-	spaceId, err := o.GetOutputVariable(t, initialiseModuleDir, "octopus_space_id")
+	spaceId, err := o.GetOutputVariableContext(ctx, t, initialiseModuleDir, o.getVariableNames().SpaceIdOutput)
 
 	if err != nil || len(strings.TrimSpace(spaceId)) == 0 {
 		// I've seen number of tests fail because the state file is blank and there is no output to read.
 		// We offer a workaround for this by setting the default space ID, which is usually Spaces-2
-		if os.Getenv("OCTOTESTDEFAULTSPACEID") != "" {
-			spaceId = os.Getenv("OCTOTESTDEFAULTSPACEID")
+		if o.getDefaultSpaceId() != "" {
+			spaceId = o.getDefaultSpaceId()
 			return spaceId, nil
 		} else {
 			return "", err
@@ -981,13 +1329,3 @@ func (o *OctopusContainerTest) ActWithCustomPrePopulatedSpace(t *testing.T, cont
 
 	return spaceId, err
 }
-
-func (o *OctopusContainerTest) copyDir(source string) (string, error) {
-	dest, err := os.MkdirTemp("", "octoterra")
-	if err != nil {
-		return "", err
-	}
-	err = cp.Copy(source, dest)
-
-	return dest, err
-}
@@ -1,12 +1,29 @@
 package test
 
 import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
 	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/OctopusDeploy/go-octopusdeploy/v2/pkg/client"
 	"github.com/OctopusDeploy/go-octopusdeploy/v2/pkg/environments"
 	"github.com/OctopusSolutionsEngineering/OctopusTerraformTestFramework/v2/octoclient"
+	"github.com/OctopusSolutionsEngineering/OctopusTerraformTestFramework/v2/octofake"
+	"github.com/OctopusSolutionsEngineering/OctopusTerraformTestFramework/v2/spaceexport"
+	cp "github.com/otiai10/copy"
+	"github.com/testcontainers/testcontainers-go"
 )
 
 func TestCustomEnvironmentVariablesCanBeNil(t *testing.T) {
@@ -66,6 +83,74 @@ func TestContainerWithNoSpecifiedVersionWillUseLatest(t *testing.T) {
 	}
 }
 
+func TestSettingsOverrideEnvironmentVariables(t *testing.T) {
+	t.Setenv("OCTOTESTVERSION", "2024.1")
+	t.Setenv("OCTOTESTRETRYCOUNT", "5")
+	t.Setenv("OCTOTESTAPIKEY", "API-ENVIRONMENTVARIABLE")
+
+	sut := OctopusContainerTest{
+		OctopusVersion: "2025.1",
+		RetryCount:     1,
+		ApiKey:         "API-SETTING",
+	}
+
+	if version := sut.getOctopusVersion(); version != "2025.1" {
+		t.Errorf("The OctopusServer version is %v", version)
+	}
+
+	if count := sut.getRetryCount(); count != 1 {
+		t.Errorf("The retry count is %v", count)
+	}
+
+	if apiKey := sut.GetApiKey(); apiKey != "API-SETTING" {
+		t.Errorf("The API key is %v", apiKey)
+	}
+}
+
+func TestEnvironmentVariablesAreUsedAsDefaults(t *testing.T) {
+	t.Setenv("OCTOTESTIMAGEURL", "example/octopus")
+	t.Setenv("OCTO_MSSQLTAG", "2022-latest")
+	t.Setenv("OCTODISABLEDIND", "N")
+
+	sut := OctopusContainerTest{}
+
+	if image := sut.getOctopusImageUrl(); image != "example/octopus" {
+		t.Errorf("The OctopusServer image is %v", image)
+	}
+
+	if tag := sut.getMSSQLTaggedVersion(); tag != ":2022-latest" {
+		t.Errorf("The MSSQL tag is %v", tag)
+	}
+
+	if disableDind := sut.getDisableDind(); disableDind != "N" {
+		t.Errorf("The DISABLE_DIND setting is %v", disableDind)
+	}
+}
+
+func TestPhaseErrorNamesThePhaseWhenContextIsCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err := phaseError(ctx, "running terraform apply", errors.New("signal: interrupt"))
+
+	var timeoutError *TimeoutError
+	if !errors.As(err, &timeoutError) || timeoutError.Phase != "running terraform apply" {
+		t.Fatalf("Expected a timeout error naming the phase, got %v", err)
+	}
+
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected the timeout error to wrap the context error, got %v", err)
+	}
+}
+
+func TestPhaseErrorReturnsOriginalErrorWhenContextIsActive(t *testing.T) {
+	original := errors.New("exit status 1")
+
+	if err := phaseError(context.Background(), "running terraform apply", original); err != original {
+		t.Errorf("Expected the original error, got %v", err)
+	}
+}
+
 // TestCreateEnvironments is an example of the kind of tests that can be written using the OctopusContainerTest framework.
 func TestCreateEnvironments(t *testing.T) {
 	testFramework := OctopusContainerTest{}
@@ -80,7 +165,7 @@ func TestCreateEnvironments(t *testing.T) {
 			return err
 		}
 
-		newSpaceClient, err := octoclient.CreateClient(container.URI, newSpaceId, ApiKey)
+		newSpaceClient, err := octoclient.CreateClient(container.URI, newSpaceId, testFramework.GetApiKey())
 
 		if err != nil {
 			return err
@@ -99,3 +184,1457 @@ func TestCreateEnvironments(t *testing.T) {
 		return nil
 	})
 }
+
+func TestStopSharedStackWithoutStartIsNoop(t *testing.T) {
+	sut := OctopusContainerTest{}
+
+	if err := sut.StopSharedStack(); err != nil {
+		t.Errorf("Stopping a shared stack that was never started returned %v", err)
+	}
+}
+
+func TestDriftErrorsAreNotRetried(t *testing.T) {
+	if isRetryable(fmt.Errorf("wrapped: %w", &DriftError{Dir: "module", Plan: "~ update in-place"})) {
+		t.Error("Drift errors should not be retried")
+	}
+
+	if isRetryable(&RoundTripError{SourceSpaceId: "Spaces-2", TargetSpaceId: "Spaces-3"}) {
+		t.Error("Round trip errors should not be retried")
+	}
+
+	if !isRetryable(errors.New("exit status 1")) {
+		t.Error("Other errors should be retried")
+	}
+}
+
+func TestAfterTestActionsRunInReverseOrder(t *testing.T) {
+	sut := OctopusContainerTest{}
+	order := []string{}
+
+	sut.afterTest(t, func() error {
+		order = append(order, "first")
+		return nil
+	})
+	sut.afterTest(t, func() error {
+		order = append(order, "second")
+		return nil
+	})
+
+	if err := sut.runAfterTest(t); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(order) != 2 || order[0] != "second" || order[1] != "first" {
+		t.Errorf("The actions ran in the order %v", order)
+	}
+
+	if err := sut.runAfterTest(t); err != nil || len(order) != 2 {
+		t.Errorf("The actions should only run once")
+	}
+}
+
+func TestVerifySpaceIsEmptyReportsLeftoverResources(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/spaces/Spaces-2":
+			fmt.Fprint(w, `{"Id": "Spaces-2"}`)
+		case "/api/Spaces-2/environments":
+			fmt.Fprint(w, `{"Items": [{"Id": "Environments-1", "Name": "Development"}], "Links": {}}`)
+		case "/api/Spaces-2/lifecycles":
+			fmt.Fprint(w, `{"Items": [{"Id": "Lifecycles-1", "Name": "Default Lifecycle"}], "Links": {}}`)
+		default:
+			fmt.Fprint(w, `{"Items": [], "Links": {}}`)
+		}
+	}))
+	defer server.Close()
+
+	sut := OctopusContainerTest{}
+	err := sut.verifySpaceIsEmpty(context.Background(), server.URL, "Spaces-2")
+
+	var leftoverError *LeftoverResourcesError
+	if !errors.As(err, &leftoverError) {
+		t.Fatalf("Expected a leftover resources error, got %v", err)
+	}
+
+	if len(leftoverError.Resources) != 1 || len(leftoverError.Resources["environments"]) != 1 {
+		t.Errorf("Expected only the Development environment to be reported, got %v", err)
+	}
+}
+
+func TestVerifySpaceIsEmptyAcceptsDeletedSpace(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	defer server.Close()
+
+	sut := OctopusContainerTest{}
+	if err := sut.verifySpaceIsEmpty(context.Background(), server.URL, "Spaces-2"); err != nil {
+		t.Errorf("A deleted space should be reported as empty, got %v", err)
+	}
+}
+
+func TestExecutorDefaultsToTerraform(t *testing.T) {
+	t.Setenv("OCTOTESTIACBINARY", "")
+
+	sut := OctopusContainerTest{}
+
+	if binary := sut.getExecutor().Binary(); binary != "terraform" {
+		t.Errorf("The executor binary is %v", binary)
+	}
+}
+
+func TestExecutorCanBeSelectedFromEnvironment(t *testing.T) {
+	t.Setenv("OCTOTESTIACBINARY", "/usr/local/bin/tofu")
+
+	sut := OctopusContainerTest{}
+
+	if _, ok := sut.getExecutor().(OpenTofuExecutor); !ok {
+		t.Errorf("Expected the OpenTofu executor, got %T", sut.getExecutor())
+	}
+}
+
+func TestOpenTofuExecutorPassesEncryptionConfig(t *testing.T) {
+	executor := OpenTofuExecutor{EncryptionConfig: "key_provider {}"}
+
+	env := executor.Environment()
+
+	if len(env) != 1 || env[0] != "TF_ENCRYPTION=key_provider {}" {
+		t.Errorf("The executor environment is %v", env)
+	}
+}
+
+func TestParseExecutorVersion(t *testing.T) {
+	version, err := parseExecutorVersion([]byte(`{"terraform_version": "1.11.1", "platform": "linux_amd64"}`))
+
+	if err != nil || version != "1.11.1" {
+		t.Errorf("The version was %v, error was %v", version, err)
+	}
+}
+
+func TestOutputsCanBeConvertedToTypes(t *testing.T) {
+	outputs, err := parseOutputs([]byte(`{
+		"environment_ids": {"sensitive": false, "type": ["map", "string"], "value": {"Development": "Environments-1"}},
+		"project_ids": {"sensitive": false, "type": ["list", "string"], "value": ["Projects-1", "Projects-2"]},
+		"count": {"sensitive": false, "type": "number", "value": 3},
+		"password": {"sensitive": true, "type": "string", "value": "secret"}
+	}`))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	environmentIds, err := convertOutput[map[string]string](outputs, "environment_ids")
+	if err != nil || environmentIds["Development"] != "Environments-1" {
+		t.Errorf("The environment IDs were %v, error was %v", environmentIds, err)
+	}
+
+	projectIds, err := convertOutput[[]string](outputs, "project_ids")
+	if err != nil || len(projectIds) != 2 {
+		t.Errorf("The project IDs were %v, error was %v", projectIds, err)
+	}
+
+	count, err := convertOutput[int](outputs, "count")
+	if err != nil || count != 3 {
+		t.Errorf("The count was %v, error was %v", count, err)
+	}
+
+	if outputs["password"].String() != "(sensitive value)" {
+		t.Errorf("The sensitive output was not masked")
+	}
+
+	if _, err := convertOutput[string](outputs, "missing"); err == nil {
+		t.Errorf("Expected an error for a missing output")
+	}
+}
+
+func TestSnapshotKeyDependsOnSettings(t *testing.T) {
+	first := OctopusContainerTest{OctopusVersion: "2024.1", CustomEnvironment: map[string]string{"A": "1", "B": "2"}}
+	second := OctopusContainerTest{OctopusVersion: "2024.1", CustomEnvironment: map[string]string{"B": "2", "A": "1"}}
+	third := OctopusContainerTest{OctopusVersion: "2024.2", CustomEnvironment: map[string]string{"A": "1", "B": "2"}}
+
+	if first.getSnapshotKey() != second.getSnapshotKey() {
+		t.Errorf("The snapshot key should not depend on the order of the custom environment")
+	}
+
+	if first.getSnapshotKey() == third.getSnapshotKey() {
+		t.Errorf("The snapshot key should depend on the Octopus version")
+	}
+}
+
+func TestSnapshotsAreIgnoredWhenDisabled(t *testing.T) {
+	t.Setenv("OCTOTESTDBSNAPSHOT", "")
+
+	testFramework := OctopusContainerTest{}
+
+	restored, err := testFramework.restoreDatabaseSnapshot(context.Background(), nil)
+
+	if err != nil || restored {
+		t.Errorf("Expected no snapshot to be restored, restored was %v, error was %v", restored, err)
+	}
+
+	if err := testFramework.takeDatabaseSnapshot(context.Background(), nil); err != nil {
+		t.Errorf("Expected no snapshot to be taken, error was %v", err)
+	}
+}
+
+func TestSnapshotsInProgressAreNotTakenAgain(t *testing.T) {
+	testFramework := OctopusContainerTest{UseDatabaseSnapshot: Bool(true), ApiKey: "API-SNAPSHOTINPROGRESS"}
+	key := testFramework.getSnapshotKey()
+
+	databaseSnapshotsMutex.Lock()
+	snapshotsInProgress[key] = true
+	databaseSnapshotsMutex.Unlock()
+
+	defer func() {
+		databaseSnapshotsMutex.Lock()
+		delete(snapshotsInProgress, key)
+		databaseSnapshotsMutex.Unlock()
+	}()
+
+	// The MSSQL container is not used, as the snapshot is already being taken by another stack
+	if err := testFramework.takeDatabaseSnapshot(context.Background(), nil); err != nil {
+		t.Errorf("Expected the snapshot in progress to be left to the other stack, error was %v", err)
+	}
+
+	if _, ok := testFramework.getDatabaseSnapshot(); ok {
+		t.Errorf("Expected no snapshot to be stored")
+	}
+}
+
+func TestSpaceResourcesExcludeTheDefaultResources(t *testing.T) {
+	server := octofake.NewServer(ApiKey)
+	defer server.Close()
+
+	for i := 0; i < 35; i++ {
+		server.AddResource(octofake.DefaultSpaceId, "environments", map[string]any{"Name": fmt.Sprintf("Environment %d", i)})
+	}
+
+	// getSpaceResources excludes the resources created with every space
+	testFramework := OctopusContainerTest{}
+	resources, err := testFramework.getSpaceResources(context.Background(), server.GetURI(), octofake.DefaultSpaceId, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(resources) != 1 || len(resources["environments"]) != 35 {
+		t.Errorf("Expected only the 35 environments, found %v", resources)
+	}
+}
+
+// TestInstanceTestsRunAgainstAnExternalServer uses the fake server as an existing server supplied in the settings
+func TestInstanceTestsRunAgainstAnExternalServer(t *testing.T) {
+	server := octofake.NewServer(ApiKey)
+	defer server.Close()
+
+	testFramework := OctopusContainerTest{ServerUrl: server.GetURI() + "/"}
+	testFramework.ArrangeInstanceTest(t, func(t *testing.T, instance OctopusInstance, testClient *client.Client) error {
+		if _, ok := instance.(*ExternalOctopus); !ok {
+			return fmt.Errorf("expected an external instance, found %T", instance)
+		}
+
+		spaceId, err := instance.CreateSpace(context.Background(), "Test")
+		if err != nil {
+			return err
+		}
+
+		if len(server.Resources("", "spaces")) != 2 {
+			return errors.New("the space was not created")
+		}
+
+		if err := instance.DeleteSpace(context.Background(), spaceId); err != nil {
+			return err
+		}
+
+		if len(server.Resources("", "spaces")) != 1 {
+			return errors.New("the space was not deleted")
+		}
+
+		return nil
+	})
+}
+
+func TestSpacesCreatedOnAnExternalServerAreDeleted(t *testing.T) {
+	server := octofake.NewServer(ApiKey)
+	defer server.Close()
+
+	testFramework := OctopusContainerTest{ServerUrl: server.GetURI()}
+	testFramework.ArrangeInstanceTest(t, func(t *testing.T, instance OctopusInstance, testClient *client.Client) error {
+		spaceId, err := instance.CreateSpace(context.Background(), "Test")
+		if err != nil {
+			return err
+		}
+
+		// Act records the spaces created by the modules it applies
+		testFramework.recordCreatedSpace(t, spaceId)
+		return nil
+	})
+
+	if spaces := server.Resources("", "spaces"); len(spaces) != 1 || spaces[0]["Id"] != octofake.DefaultSpaceId {
+		t.Errorf("Expected only the default space to remain, found %v", spaces)
+	}
+}
+
+func TestInstanceTestsCanUseTheFakeServer(t *testing.T) {
+	t.Setenv("OCTOTESTSERVERURL", "")
+	t.Setenv("OCTOTESTFAKESERVER", "true")
+
+	testFramework := OctopusContainerTest{}
+	testFramework.ArrangeInstanceTest(t, func(t *testing.T, instance OctopusInstance, testClient *client.Client) error {
+		if _, ok := instance.(*octofake.Server); !ok {
+			return fmt.Errorf("expected the fake server, found %T", instance)
+		}
+
+		logs, err := instance.GetLogs(context.Background())
+		if err != nil {
+			return err
+		}
+
+		if logs == "" {
+			return errors.New("expected the requests made by the client to be logged")
+		}
+
+		return nil
+	})
+}
+
+// getEnvironmentVariable returns the value of a variable from a list of "KEY=value" strings
+func getEnvironmentVariable(environment []string, name string) string {
+	for _, variable := range environment {
+		if value, ok := strings.CutPrefix(variable, name+"="); ok {
+			return value
+		}
+	}
+
+	return ""
+}
+
+func TestLocalProviderGeneratesDevOverrides(t *testing.T) {
+	providerDir := t.TempDir()
+	testFramework := OctopusContainerTest{LocalProviderDir: providerDir, DisablePluginCache: Bool(true)}
+
+	environment, err := testFramework.cliEnvironment(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	configFile := getEnvironmentVariable(environment, "TF_CLI_CONFIG_FILE")
+	if configFile == "" {
+		t.Fatalf("Expected TF_CLI_CONFIG_FILE to be set, found %v", environment)
+	}
+
+	config, err := os.ReadFile(configFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(string(config), "dev_overrides") || !strings.Contains(string(config), `"octopusdeploylabs/octopusdeploy" = "`+providerDir+`"`) {
+		t.Errorf("The CLI configuration did not override the provider:\n%s", config)
+	}
+
+	t.Setenv("OCTOTESTSKIPINIT", "")
+	if testFramework.getSkipInit() {
+		t.Errorf("Init should still run when testing a local provider, as other providers and modules must be installed")
+	}
+}
+
+func TestCliConfigIsNotGeneratedByDefault(t *testing.T) {
+	t.Setenv("OCTOTESTPROVIDERDIR", "")
+	t.Setenv("OCTOTESTPROVIDERMIRROR", "")
+
+	testFramework := OctopusContainerTest{PluginCacheDir: t.TempDir()}
+	environment, err := testFramework.cliEnvironment(t)
+
+	if err != nil || getEnvironmentVariable(environment, "TF_CLI_CONFIG_FILE") != "" {
+		t.Errorf("Expected no CLI configuration, found %v, error was %v", environment, err)
+	}
+}
+
+func TestPluginCacheIsShared(t *testing.T) {
+	cacheDir := t.TempDir()
+	testFramework := OctopusContainerTest{PluginCacheDir: cacheDir}
+
+	environment, err := testFramework.cliEnvironment(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if getEnvironmentVariable(environment, "TF_PLUGIN_CACHE_DIR") != cacheDir {
+		t.Errorf("Expected the plugin cache to be %s, found %v", cacheDir, environment)
+	}
+
+	if getEnvironmentVariable(environment, "TF_PLUGIN_CACHE_MAY_BREAK_DEPENDENCY_LOCK_FILE") != "true" {
+		t.Errorf("Expected the cache to be used without a lock file, found %v", environment)
+	}
+}
+
+func TestInitsRunConcurrentlyOnceTheModuleIsCached(t *testing.T) {
+	testFramework := OctopusContainerTest{PluginCacheDir: t.TempDir()}
+	moduleDir := t.TempDir()
+
+	if err := testFramework.lockPluginCache(t, moduleDir, func() error { return nil }); err != nil {
+		t.Fatal(err)
+	}
+
+	// Each init waits for the other to start, which only succeeds if they run at the same time
+	started := make(chan bool)
+	errs := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			errs <- testFramework.lockPluginCache(t, moduleDir, func() error {
+				select {
+				case started <- true:
+				case <-started:
+				case <-time.After(10 * time.Second):
+					return errors.New("the inits of a cached module were run one at a time")
+				}
+				return nil
+			})
+		}()
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := <-errs; err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+func TestProviderMirrorDisablesDirectInstallation(t *testing.T) {
+	mirrorDir := t.TempDir()
+	testFramework := OctopusContainerTest{ProviderMirrorDir: mirrorDir, DisablePluginCache: Bool(true)}
+
+	config, err := testFramework.buildCliConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(config, "filesystem_mirror") || !strings.Contains(config, strconv.Quote(mirrorDir)) || strings.Contains(config, "direct") {
+		t.Errorf("The CLI configuration should only install providers from the mirror:\n%s", config)
+	}
+}
+
+func TestWorkingCopiesResolveRelativeModules(t *testing.T) {
+	sourceDir := t.TempDir()
+	moduleDir := filepath.Join(sourceDir, "root")
+	sharedDir := filepath.Join(sourceDir, "modules", "shared")
+
+	for _, dir := range []string{filepath.Join(moduleDir, ".terraform"), sharedDir} {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	files := map[string]string{
+		filepath.Join(moduleDir, "main.tf"):           "module \"shared\" {\n  source = \"../modules/shared\"\n}\n",
+		filepath.Join(moduleDir, "terraform.tfstate"): "{}",
+		filepath.Join(sharedDir, "main.tf"):           "output \"value\" {\n  value = \"shared\"\n}\n",
+	}
+	for file, content := range files {
+		if err := os.WriteFile(file, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	testFramework := OctopusContainerTest{}
+	workingCopy, err := testFramework.createWorkingCopy(t, moduleDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(filepath.Join(workingCopy, "..", "modules", "shared", "main.tf")); err != nil {
+		t.Errorf("The relative module was not copied: %v", err)
+	}
+
+	for _, generated := range []string{".terraform", "terraform.tfstate"} {
+		if _, err := os.Stat(filepath.Join(workingCopy, generated)); !os.IsNotExist(err) {
+			t.Errorf("The generated file %s should not be copied", generated)
+		}
+	}
+
+	if testFramework.getWorkingCopy(t, moduleDir) != workingCopy {
+		t.Errorf("Commands run against the module should use the working copy")
+	}
+}
+
+// TestParallelStacksAreIsolated applies the same module in two parallel tests, each with its own stack
+func TestParallelStacksAreIsolated(t *testing.T) {
+	testFramework := OctopusContainerTest{}
+	containerIds := sync.Map{}
+
+	for _, name := range []string{"first", "second"} {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			testFramework.ArrangeTest(t, func(t *testing.T, container *OctopusContainer, client *client.Client) error {
+				containerIds.Store(name, container.GetContainerID())
+
+				newSpaceId, err := testFramework.Act(t, container, filepath.Join("..", "terraform"), "2-simpleexample", []string{})
+				if err != nil {
+					return err
+				}
+
+				newSpaceClient, err := octoclient.CreateClient(container.URI, newSpaceId, testFramework.GetApiKey())
+				if err != nil {
+					return err
+				}
+
+				// Each test must only see the environments created by its own module
+				testEnvironments, err := environments.GetAll(newSpaceClient, newSpaceId)
+				if err != nil {
+					return err
+				}
+
+				if len(testEnvironments) != 3 {
+					return fmt.Errorf("expected 3 environments, got %d", len(testEnvironments))
+				}
+
+				return nil
+			})
+		})
+	}
+
+	t.Cleanup(func() {
+		first, _ := containerIds.Load("first")
+		second, _ := containerIds.Load("second")
+
+		if first != nil && first == second {
+			t.Errorf("The parallel tests shared the container %v", first)
+		}
+	})
+}
+
+// TestParallelTestsHaveSeparateWorkingCopies runs two tests concurrently against the same module
+func TestParallelTestsHaveSeparateWorkingCopies(t *testing.T) {
+	moduleDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(moduleDir, "main.tf"), []byte("output \"value\" {\n  value = \"test\"\n}\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	testFramework := OctopusContainerTest{}
+	workingCopies := sync.Map{}
+
+	t.Run("group", func(t *testing.T) {
+		for _, name := range []string{"first", "second"} {
+			t.Run(name, func(t *testing.T) {
+				t.Parallel()
+
+				workingCopy, err := testFramework.createWorkingCopy(t, moduleDir)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				// Simulate terraform writing state into the working copy
+				if err := os.WriteFile(filepath.Join(workingCopy, "terraform.tfstate"), []byte(name), 0o644); err != nil {
+					t.Fatal(err)
+				}
+
+				state, err := os.ReadFile(filepath.Join(testFramework.getWorkingCopy(t, moduleDir), "terraform.tfstate"))
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if string(state) != name {
+					t.Errorf("The %s test read the state written by the %s test", name, string(state))
+				}
+
+				workingCopies.Store(name, workingCopy)
+			})
+		}
+	})
+
+	first, _ := workingCopies.Load("first")
+	second, _ := workingCopies.Load("second")
+	if first == second {
+		t.Errorf("The parallel tests shared the working copy %v", first)
+	}
+
+	if _, err := os.Stat(filepath.Join(moduleDir, "terraform.tfstate")); !os.IsNotExist(err) {
+		t.Errorf("State should not be written to the source module")
+	}
+}
+
+// TestParallelFakeTestsDoNotShareServers runs two tests concurrently against their own fake servers
+func TestParallelFakeTestsDoNotShareServers(t *testing.T) {
+	testFramework := OctopusContainerTest{}
+
+	for _, name := range []string{"first", "second"} {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			testFramework.ArrangeFakeTest(t, func(t *testing.T, server OctopusInstance, testClient *client.Client) error {
+				if _, err := environments.Add(testClient, environments.NewEnvironment(name)); err != nil {
+					return err
+				}
+
+				all, err := environments.GetAll(testClient, testClient.GetSpaceID())
+				if err != nil {
+					return err
+				}
+
+				if len(all) != 1 || all[0].Name != name {
+					return fmt.Errorf("the %s test found environments from another test", name)
+				}
+
+				return nil
+			})
+		})
+	}
+}
+
+func TestStackLimitIsEnforced(t *testing.T) {
+	testFramework := OctopusContainerTest{MaxConcurrentStacks: 1}
+
+	if err := testFramework.acquireStack(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	// A second stack must wait until the first is released
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	var timeoutError *TimeoutError
+	if err := testFramework.acquireStack(ctx); !errors.As(err, &timeoutError) {
+		t.Errorf("Expected a TimeoutError, found %v", err)
+	}
+
+	acquired := make(chan error)
+	go func() {
+		acquired <- testFramework.acquireStack(context.Background())
+	}()
+
+	testFramework.releaseStack()
+
+	if err := <-acquired; err != nil {
+		t.Errorf("Expected the stack to be acquired once the first was released, found %v", err)
+	}
+
+	testFramework.releaseStack()
+}
+
+func TestParseAvailableMemory(t *testing.T) {
+	meminfo := "MemTotal:       16318480 kB\nMemFree:         1096572 kB\nMemAvailable:    8388608 kB\n"
+
+	available, ok := parseAvailableMemory(bufio.NewScanner(strings.NewReader(meminfo)))
+
+	if !ok || available != 8*1024*1024*1024 {
+		t.Errorf("Expected 8GB to be available, found %d", available)
+	}
+}
+
+// TestStackPoolReusesStacks runs two tests against a pool with a single stack
+func TestStackPoolReusesStacks(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test")
+	}
+
+	testFramework := OctopusContainerTest{StackPoolSize: 1}
+	testFramework.StartStackPool()
+	defer func() {
+		if err := testFramework.StopStackPool(); err != nil {
+			t.Error(err)
+		}
+	}()
+
+	containerIds := []string{}
+
+	for _, name := range []string{"first", "second"} {
+		t.Run(name, func(t *testing.T) {
+			testFramework.ArrangeTest(t, func(t *testing.T, container *OctopusContainer, client *client.Client) error {
+				containerIds = append(containerIds, container.GetContainerID())
+
+				// The space created by the first test must have been deleted
+				spaceIds, err := getSpaceIds(context.Background(), container.URI, testFramework.GetApiKey())
+				if err != nil {
+					return err
+				}
+
+				if len(spaceIds) != 1 {
+					return fmt.Errorf("expected only the default space, found %v", spaceIds)
+				}
+
+				_, err = testFramework.Act(t, container, filepath.Join("..", "terraform"), "2-simpleexample", []string{})
+				return err
+			})
+		})
+	}
+
+	if len(containerIds) != 2 || containerIds[0] != containerIds[1] {
+		t.Errorf("Expected both tests to lease the same stack, found %v", containerIds)
+	}
+}
+
+func TestResetSpacesDeletesNewSpaces(t *testing.T) {
+	server := octofake.NewServer(ApiKey)
+	defer server.Close()
+
+	ctx := context.Background()
+
+	keep, err := getSpaceIds(ctx, server.GetURI(), ApiKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := createSpace(ctx, server.GetURI(), ApiKey, "Test"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := resetSpaces(ctx, server.GetURI(), ApiKey, keep); err != nil {
+		t.Fatal(err)
+	}
+
+	remaining, err := getSpaceIds(ctx, server.GetURI(), ApiKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !slices.Equal(keep, remaining) {
+		t.Errorf("Expected the spaces %v, found %v", keep, remaining)
+	}
+}
+
+func TestExportServerDetectsChangesToKeptSpaces(t *testing.T) {
+	server := octofake.NewServer(ApiKey)
+	defer server.Close()
+
+	ctx := context.Background()
+
+	keep, err := getSpaceIds(ctx, server.GetURI(), ApiKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	baseline, err := exportServer(ctx, server, keep)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	unchanged, err := exportServer(ctx, server, keep)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if differences := spaceexport.Compare(baseline, unchanged); len(differences) != 0 {
+		t.Errorf("Expected no differences, found %v", differences)
+	}
+
+	server.AddResource(octofake.DefaultSpaceId, "environments", map[string]any{"Name": "Leaked"})
+
+	changed, err := exportServer(ctx, server, keep)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	differences := spaceexport.Compare(baseline, changed)
+	if len(differences) != 1 || !strings.Contains(differences[0], "Leaked") {
+		t.Errorf("Expected the new environment in the default space to be reported, found %v", differences)
+	}
+}
+
+func TestRemovingPooledStacksReleasesTheStackLimit(t *testing.T) {
+	testFramework := OctopusContainerTest{MaxConcurrentStacks: 1}
+	pool := &StackPool{testFramework: &testFramework, ctx: context.Background(), stacks: map[*pooledStack]bool{}}
+
+	if err := testFramework.acquireStack(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	stack := &pooledStack{}
+	pool.stacks[stack] = true
+
+	// Removing the stack twice must only release it once
+	for i := 0; i < 2; i++ {
+		if err := pool.remove(stack); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := testFramework.acquireStack(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	defer testFramework.releaseStack()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	var timeoutError *TimeoutError
+	if err := testFramework.acquireStack(ctx); !errors.As(err, &timeoutError) {
+		t.Errorf("Expected the stack limit to be reached, found %v", err)
+	}
+}
+
+func TestStackPoolSettingsFallBackToEnvironmentVariables(t *testing.T) {
+	t.Setenv("OCTOTESTPOOLSIZE", "3")
+	t.Setenv("OCTOTESTPOOLMAXLIFETIME", "15m")
+
+	sut := OctopusContainerTest{}
+
+	if sut.getStackPoolSize() != 3 {
+		t.Errorf("Expected a pool size of 3, found %d", sut.getStackPoolSize())
+	}
+
+	if sut.getStackPoolMaxLifetime() != 15*time.Minute {
+		t.Errorf("Expected a maximum lifetime of 15m, found %v", sut.getStackPoolMaxLifetime())
+	}
+
+	sut = OctopusContainerTest{StackPoolSize: 1, StackPoolMaxLifetime: time.Minute}
+
+	if sut.getStackPoolSize() != 1 || sut.getStackPoolMaxLifetime() != time.Minute {
+		t.Errorf("Expected the settings to take precedence over the environment variables")
+	}
+}
+
+func TestBoolSettingsOverrideEnvironmentVariables(t *testing.T) {
+	t.Setenv("OCTOTESTCHECKDRIFT", "true")
+	t.Setenv("OCTOTESTWAITFORAPI", "false")
+	t.Setenv("OCTODISABLEDIND", "N")
+
+	sut := OctopusContainerTest{}
+	if !sut.getCheckForDrift() || !sut.getSkipWaitForApi() || sut.getDisableDind() != "N" {
+		t.Errorf("Expected unset settings to fall back to the environment variables")
+	}
+
+	sut = OctopusContainerTest{CheckForDrift: Bool(false), SkipWaitForApi: Bool(false), EnableDind: Bool(false)}
+	if sut.getCheckForDrift() || sut.getSkipWaitForApi() || sut.getDisableDind() != "Y" {
+		t.Errorf("Expected settings of false to take precedence over the environment variables")
+	}
+}
+
+func TestFailureArtifactsAreWritten(t *testing.T) {
+	server := octofake.NewServer(ApiKey)
+	defer server.Close()
+
+	testFramework := OctopusContainerTest{ArtifactsDir: t.TempDir()}
+	testFramework.setTestInstance(t, server, nil)
+
+	_, err := testFramework.commandOutput(t, exec.Command("sh", "-c", "echo stdout message; echo stderr message >&2; exit 1"))
+
+	var exitError *exec.ExitError
+	if !errors.As(err, &exitError) || !strings.Contains(string(exitError.Stderr), "stderr message") {
+		t.Fatalf("Expected the stderr to be returned in the error, found %v", err)
+	}
+
+	testFramework.writeFailureArtifacts(context.Background(), t)
+
+	dirs, err := filepath.Glob(filepath.Join(testFramework.ArtifactsDir, "TestFailureArtifactsAreWritten-*"))
+	if err != nil || len(dirs) != 1 {
+		t.Fatalf("Expected one artifact directory, found %v", dirs)
+	}
+
+	commands, err := filepath.Glob(filepath.Join(dirs[0], "terraform", "01-*.log"))
+	if err != nil || len(commands) != 1 {
+		t.Fatalf("Expected the command output to be written, found %v", commands)
+	}
+
+	output, err := os.ReadFile(commands[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(string(output), "stdout message") || !strings.Contains(string(output), "stderr message") {
+		t.Errorf("Expected the command output to include stdout and stderr, found %s", string(output))
+	}
+
+	for _, file := range []string{"octopus.log", filepath.Join("spaces", octofake.DefaultSpaceId+".json")} {
+		if _, err := os.Stat(filepath.Join(dirs[0], file)); err != nil {
+			t.Errorf("Expected the artifact %s to be written: %v", file, err)
+		}
+	}
+}
+
+func TestFailureArtifactsAreWrittenWhenTheTestFunctionCallsFatal(t *testing.T) {
+	artifactsDir := t.TempDir()
+
+	// t.Fatal fails the test calling it, so the failing test is run in a separate process
+	cmnd := exec.Command(os.Args[0], "-test.run=^TestFatalTestFunction$")
+	cmnd.Env = append(os.Environ(), "OCTOTESTFATALARTIFACTSDIR="+artifactsDir)
+	if out, err := cmnd.CombinedOutput(); err == nil {
+		t.Fatalf("Expected the test function to fail:\n%s", out)
+	}
+
+	dirs, err := filepath.Glob(filepath.Join(artifactsDir, "TestFatalTestFunction-*"))
+	if err != nil || len(dirs) != 1 {
+		t.Errorf("Expected one artifact directory, found %v", dirs)
+	}
+
+	if _, err := os.Stat(filepath.Join(artifactsDir, "after-test")); err != nil {
+		t.Errorf("Expected the after test actions to run: %v", err)
+	}
+}
+
+// TestFatalTestFunction is run by TestFailureArtifactsAreWrittenWhenTheTestFunctionCallsFatal
+func TestFatalTestFunction(t *testing.T) {
+	artifactsDir := os.Getenv("OCTOTESTFATALARTIFACTSDIR")
+	if artifactsDir == "" {
+		t.Skip("only run by TestFailureArtifactsAreWrittenWhenTheTestFunctionCallsFatal")
+	}
+
+	testFramework := OctopusContainerTest{ArtifactsDir: artifactsDir}
+	testFramework.ArrangeFakeTest(t, func(t *testing.T, server OctopusInstance, client *client.Client) error {
+		testFramework.afterTest(t, func() error {
+			return os.WriteFile(filepath.Join(artifactsDir, "after-test"), []byte{}, 0o644)
+		})
+
+		t.Fatal("the test function failed")
+		return nil
+	})
+}
+
+func TestContainerLogsAreFilteredByLevel(t *testing.T) {
+	logDir := t.TempDir()
+	consumer := NewContainerLogConsumer("octopus", LogLevelWarn, logDir)
+
+	t.Run("routed", func(t *testing.T) {
+		consumer.attach(t)
+
+		consumer.Accept(testcontainers.Log{Content: []byte("2025-01-01 00:00:00.0000 ERROR The server failed\n")})
+		consumer.Accept(testcontainers.Log{Content: []byte("2025-01-01 00:00:00.0000 WARN  The disk is nearly full\n")})
+		consumer.Accept(testcontainers.Log{Content: []byte("2025-01-01 00:00:00.0000 INFO  Retrying after an error\n")})
+		consumer.Accept(testcontainers.Log{Content: []byte("2025-01-01 00:00:00.0000 DEBUG Checking the task queue\n")})
+	})
+
+	content, err := os.ReadFile(filepath.Join(logDir, "TestContainerLogsAreFilteredByLevel_routed.octopus.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(string(content)), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("Expected the error and warning lines, found %v", lines)
+	}
+
+	if !strings.Contains(lines[0], "[octopus] 2025-01-01 00:00:00.0000 ERROR The server failed") {
+		t.Errorf("Expected the line to be prefixed with the role, found %s", lines[0])
+	}
+}
+
+func TestContainerLogsAreRoutedToTheLatestRunningTest(t *testing.T) {
+	logDir := t.TempDir()
+	consumer := NewContainerLogConsumer("octopus", LogLevelInfo, logDir)
+
+	t.Run("first", func(t *testing.T) {
+		consumer.attach(t)
+		consumer.Accept(testcontainers.Log{Content: []byte("before the second test\n")})
+
+		t.Run("second", func(t *testing.T) {
+			consumer.attach(t)
+			consumer.Accept(testcontainers.Log{Content: []byte("during the second test\n")})
+		})
+
+		consumer.Accept(testcontainers.Log{Content: []byte("after the second test\n")})
+	})
+
+	expected := map[string][]string{
+		"TestContainerLogsAreRoutedToTheLatestRunningTest_first.octopus.log":        {"before the second test", "after the second test"},
+		"TestContainerLogsAreRoutedToTheLatestRunningTest_first_second.octopus.log": {"during the second test"},
+	}
+
+	for file, messages := range expected {
+		content, err := os.ReadFile(filepath.Join(logDir, file))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		lines := strings.Split(strings.TrimSpace(string(content)), "\n")
+		if len(lines) != len(messages) {
+			t.Fatalf("Expected %v in %s, found %v", messages, file, lines)
+		}
+
+		for i, message := range messages {
+			if !strings.HasSuffix(lines[i], message) {
+				t.Errorf("Expected %s in %s, found %s", message, file, lines[i])
+			}
+		}
+	}
+}
+
+func TestDeprecatedLoggingSettingsHideContainerLogs(t *testing.T) {
+	t.Setenv("OCTOTESTOCTOPUSLOGLEVEL", "")
+	t.Setenv("OCTOTESTMSSQLLOGLEVEL", "error")
+	t.Setenv("OCTODISABLEMSSQLCONTAINERLOGGING", "")
+
+	sut := OctopusContainerTest{DisableOctopusContainerLogging: Bool(true)}
+
+	if sut.getOctopusContainerLogLevel() != LogLevelNone {
+		t.Errorf("Expected the Octopus logs to be hidden, found %s", sut.getOctopusContainerLogLevel())
+	}
+
+	if sut.getMSSQLContainerLogLevel() != LogLevelError {
+		t.Errorf("Expected the MSSQL log level to be read from the environment, found %s", sut.getMSSQLContainerLogLevel())
+	}
+
+	sut = OctopusContainerTest{}
+	if sut.getOctopusContainerLogLevel() != LogLevelInfo {
+		t.Errorf("Expected the default log level to be info, found %s", sut.getOctopusContainerLogLevel())
+	}
+}
+
+func TestRedactMasksRegisteredSecrets(t *testing.T) {
+	testFramework := OctopusContainerTest{ApiKey: "API-REDACTIONTEST", License: "bGljZW5zZXRlc3Q="}
+	testFramework.registerSecrets()
+	RegisterSecret("short")
+
+	redacted := Redact("-var=octopus_apikey=API-REDACTIONTEST LICENSE_BASE64=bGljZW5zZXRlc3Q= SA_PASSWORD=Password01! short")
+
+	if redacted != "-var=octopus_apikey=[REDACTED] LICENSE_BASE64=[REDACTED] SA_PASSWORD=[REDACTED] short" {
+		t.Errorf("The secrets were not redacted: %s", redacted)
+	}
+}
+
+func TestSensitiveVariablesAndOutputsAreRedacted(t *testing.T) {
+	moduleDir := t.TempDir()
+	module := `variable "password" {
+  type      = string
+  sensitive = true
+}
+
+variable "name" {
+  type = string
+}
+
+variable "certificate" {
+  type = string
+  validation {
+    condition     = length(var.certificate) > 0
+    error_message = "The certificate is required."
+  }
+  sensitive = true
+}
+
+variable "credentials" {
+  type      = object({ username = string, password = string })
+  sensitive = true
+}
+`
+	if err := os.WriteFile(filepath.Join(moduleDir, "variables.tf"), []byte(module), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	registerSensitiveVariables(moduleDir, []string{
+		"-var=password=SensitiveVariableValue",
+		"-var=name=VisibleVariableValue",
+		"-var=certificate=SensitiveCertificateValue",
+		`-var=credentials={username="admin",password="SensitiveCredentialsValue"}`,
+	})
+
+	outputs, err := parseOutputs([]byte(`{"token":{"sensitive":true,"type":"string","value":"SensitiveOutputValue"}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	redacted := Redact(`SensitiveVariableValue VisibleVariableValue SensitiveCertificateValue {username="admin",password="SensitiveCredentialsValue"} ` + string(outputs["token"].Value))
+
+	if redacted != `[REDACTED] VisibleVariableValue [REDACTED] [REDACTED] "[REDACTED]"` {
+		t.Errorf("The sensitive values were not redacted: %s", redacted)
+	}
+}
+
+func TestTerraformVarsAreWrittenToAVariableFile(t *testing.T) {
+	testFramework := OctopusContainerTest{}
+	var varFile string
+
+	t.Run("apply", func(t *testing.T) {
+		args, err := testFramework.TerraformVars(t, map[string]any{
+			"environments": []string{"Development", "Test"},
+			"tags":         map[string]string{"team": "platform"},
+			"script":       "echo one\necho two",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(args) != 1 || !strings.HasPrefix(args[0], "-var-file=") || !strings.HasSuffix(args[0], ".tfvars.json") {
+			t.Fatalf("Expected a single -var-file argument, found %v", args)
+		}
+		varFile = strings.TrimPrefix(args[0], "-var-file=")
+
+		info, err := os.Stat(varFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if info.Mode().Perm() != 0o600 {
+			t.Errorf("Expected the variable file to only be readable by the owner, found %v", info.Mode().Perm())
+		}
+
+		values := readVarArgs(args)
+		if values["script"] != "echo one\necho two" || values["environments"] != `["Development","Test"]` {
+			t.Errorf("The variables were not written correctly: %v", values)
+		}
+	})
+
+	if _, err := os.Stat(varFile); !os.IsNotExist(err) {
+		t.Errorf("Expected the variable file to be removed once the test completed")
+	}
+}
+
+func TestServerVariablesAreNotPassedOnTheCommandLine(t *testing.T) {
+	testFramework := OctopusContainerTest{ApiKey: "API-COMMANDLINETEST"}
+
+	args, env, err := testFramework.terraformVarArgs(t, "http://localhost:8080", "Spaces-1", []string{"-var=name=value"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(env) != 0 {
+		t.Errorf("Expected no provider environment variables, found %v", env)
+	}
+
+	if len(args) != 2 || args[1] != "-var=name=value" {
+		t.Fatalf("Expected a variable file followed by the supplied variables, found %v", args)
+	}
+
+	if strings.Contains(strings.Join(args, " "), "API-COMMANDLINETEST") {
+		t.Errorf("The API key was passed on the command line: %v", args)
+	}
+
+	if readVarArgs(args)["octopus_apikey"] != "API-COMMANDLINETEST" {
+		t.Errorf("Expected the API key to be written to the variable file")
+	}
+}
+
+func TestVariableNamesCanBeMapped(t *testing.T) {
+	t.Setenv("OCTOTESTVARNAMES", "apikey=api_key, spacename=-")
+	t.Setenv("OCTOTESTPROVIDERENV", "")
+
+	testFramework := OctopusContainerTest{
+		ApiKey: "API-VARIABLENAMESTEST",
+		VariableNames: TerraformVariableNames{
+			Server:              "server_url",
+			SpaceId:             skipVariable,
+			SpaceIdOutput:       "space_id",
+			ProviderEnvironment: Bool(true),
+		},
+	}
+
+	args, env, err := testFramework.terraformVarArgs(t, "http://localhost:8080", "Spaces-1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	values := readVarArgs(args)
+	if len(values) != 2 || values["server_url"] != "http://localhost:8080" || values["api_key"] != "API-VARIABLENAMESTEST" {
+		t.Errorf("The server details were not passed to the mapped variables: %v", values)
+	}
+
+	if !slices.Equal(env, []string{"OCTOPUS_URL=http://localhost:8080", "OCTOPUS_APIKEY=API-VARIABLENAMESTEST"}) {
+		t.Errorf("The server details were not passed in the provider environment variables: %v", env)
+	}
+
+	names := testFramework.getVariableNames()
+	if spaceArgs := names.spaceVarArgs("MySpace", "My description"); !slices.Equal(spaceArgs, []string{"-var=octopus_space_description=My description"}) {
+		t.Errorf("Expected only the default space description variable, found %v", spaceArgs)
+	}
+
+	if names.SpaceIdOutput != "space_id" {
+		t.Errorf("Expected the space ID output to be space_id, found %s", names.SpaceIdOutput)
+	}
+}
+
+func TestPipelineStagesShareASpace(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test")
+	}
+
+	testFramework := OctopusContainerTest{}
+	testFramework.ArrangeTest(t, func(t *testing.T, container *OctopusContainer, client *client.Client) error {
+		spaceId, err := testFramework.RunPipeline(t, container, []PipelineStage{
+			{
+				ModuleDir:      filepath.Join("..", "terraform", "1-singlespace"),
+				Vars:           []string{"-var=octopus_space_name=Pipeline"},
+				ForwardOutputs: map[string]string{"octopus_space_id": "octopus_space_id"},
+				SpaceIdOutput:  "octopus_space_id",
+			},
+			{ModuleDir: filepath.Join("..", "terraform", "2-simpleexample")},
+		})
+		if err != nil {
+			return err
+		}
+
+		newSpaceClient, err := octoclient.CreateClient(container.URI, spaceId, testFramework.GetApiKey())
+		if err != nil {
+			return err
+		}
+
+		testEnvironments, err := environments.GetAll(newSpaceClient, spaceId)
+		if err != nil {
+			return err
+		}
+
+		if len(testEnvironments) != 3 {
+			return fmt.Errorf("expected 3 environments in %s, got %d", spaceId, len(testEnvironments))
+		}
+
+		return nil
+	})
+}
+
+func TestOutputsAreForwardedWithTheirTypes(t *testing.T) {
+	outputs, err := parseOutputs([]byte(`{
+		"feed_id": {"sensitive": false, "type": "string", "value": "Feeds-1"},
+		"environment_ids": {"sensitive": false, "type": ["list", "string"], "value": ["Environments-1", "Environments-2"]}
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	forwardedVars := map[string]any{}
+	if err := forwardOutputs(outputs, map[string]string{"feed_id": "docker_feed_id", "environment_ids": "environment_ids"}, forwardedVars); err != nil {
+		t.Fatal(err)
+	}
+
+	args, err := (&OctopusContainerTest{}).TerraformVars(t, forwardedVars)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	values := readVarArgs(args)
+	if values["docker_feed_id"] != "Feeds-1" || values["environment_ids"] != `["Environments-1","Environments-2"]` {
+		t.Errorf("The outputs were not forwarded correctly: %v", values)
+	}
+
+	if err := forwardOutputs(outputs, map[string]string{"project_id": "project_id"}, forwardedVars); err == nil || !strings.Contains(err.Error(), "project_id") {
+		t.Errorf("Expected an error naming the missing output, found %v", err)
+	}
+}
+
+func TestScenarios(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test")
+	}
+
+	testFramework := OctopusContainerTest{}
+	testFramework.RunScenarios(t, filepath.Join("..", "scenarios"))
+}
+
+func TestScenarioFilesAreLoaded(t *testing.T) {
+	dir := t.TempDir()
+
+	files := map[string]string{
+		"b.yaml":    "module: ../terraform/2-simpleexample\nvars:\n  tags:\n    team: platform\nexpect:\n  environments: [Development]\n",
+		"a.json":    `{"name": "From JSON", "module": "module", "spaceModule": "space"}`,
+		"bad.yml":   "module: module\nexpcet:\n  environments: [Development]\n",
+		"notes.txt": "not a scenario",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	scenarioFiles, err := findScenarioFiles(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expectedFiles := []string{filepath.Join(dir, "a.json"), filepath.Join(dir, "b.yaml"), filepath.Join(dir, "bad.yml")}
+	if !slices.Equal(scenarioFiles, expectedFiles) {
+		t.Fatalf("Expected the scenario files %v, found %v", expectedFiles, scenarioFiles)
+	}
+
+	jsonScenario, err := loadScenario(scenarioFiles[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if jsonScenario.Name != "From JSON" || jsonScenario.SpaceModule != "space" {
+		t.Errorf("The JSON scenario was not loaded correctly: %+v", jsonScenario)
+	}
+
+	yamlScenario, err := loadScenario(scenarioFiles[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	args, err := (&OctopusContainerTest{}).scenarioVars(t, yamlScenario.Vars)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if tags := readVarArgs(args)["tags"]; tags != `{"team":"platform"}` {
+		t.Errorf("The scenario variables were not passed with their types: %s", tags)
+	}
+
+	if _, err := loadScenario(scenarioFiles[2]); err == nil || !strings.Contains(err.Error(), "expcet") {
+		t.Errorf("Expected an error naming the unknown field, found %v", err)
+	}
+}
+
+func TestScenarioSpaceVarsOverrideTheDefaults(t *testing.T) {
+	dir := t.TempDir()
+
+	// A stand in for terraform, reporting the ID of the default space as the ID of the new space
+	binary := filepath.Join(dir, "terraform")
+	script := "#!/bin/sh\ncase \"$1\" in\n  version) echo '{\"terraform_version\":\"1.9.0\"}' ;;\n  output) echo '\"Spaces-1\"' ;;\nesac\n"
+	if err := os.WriteFile(binary, []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, module := range []string{"space", "module"} {
+		if err := os.MkdirAll(filepath.Join(dir, module), 0o755); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := os.WriteFile(filepath.Join(dir, module, "main.tf"), []byte{}, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	server := octofake.NewServer(ApiKey)
+	defer server.Close()
+
+	testFramework := OctopusContainerTest{Executor: TerraformExecutor{Path: binary}, SkipInit: Bool(true)}
+	err := testFramework.runScenario(context.Background(), t, server, Scenario{
+		Module:      "module",
+		SpaceModule: "space",
+		SpaceVars:   map[string]any{"octopus_space_description": "Created by a scenario"},
+		file:        filepath.Join(dir, "scenario.yaml"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	commands := getTestState(t).commands
+	if len(commands) == 0 || !slices.Contains(commands[0].Args, "apply") {
+		t.Fatalf("Expected the space module to be applied first, found %v", commands)
+	}
+
+	if description := readVarArgs(commands[0].Args)["octopus_space_description"]; description != "Created by a scenario" {
+		t.Errorf("Expected the space description from the scenario to be applied, found %q", description)
+	}
+}
+
+func TestDestroyContinuesAfterAModuleFails(t *testing.T) {
+	dir := t.TempDir()
+
+	// A stand in for terraform that fails to destroy the module in the "second" directory
+	binary := filepath.Join(dir, "terraform")
+	script := "#!/bin/sh\ncase \"$1\" in\n  version) echo '{\"terraform_version\":\"1.9.0\"}' ;;\n  destroy) [ \"$(basename \"$PWD\")\" != second ] ;;\nesac\n"
+	if err := os.WriteFile(binary, []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	modules := []appliedModule{}
+	for _, module := range []string{"first", "second"} {
+		moduleDir := filepath.Join(dir, module)
+		if err := os.MkdirAll(moduleDir, 0o755); err != nil {
+			t.Fatal(err)
+		}
+
+		modules = append(modules, appliedModule{dir: moduleDir, spaceId: "Spaces-2"})
+	}
+
+	testFramework := OctopusContainerTest{Executor: TerraformExecutor{Path: binary}}
+	err := testFramework.destroyModules(context.Background(), t, "http://127.0.0.1:1", modules, "Spaces-2")
+
+	if err == nil || !strings.Contains(err.Error(), "second") || strings.Contains(err.Error(), "verifying") {
+		t.Errorf("Expected only the failure to destroy the second module to be reported, found %v", err)
+	}
+
+	destroyed := []string{}
+	for _, command := range getTestState(t).commands {
+		if slices.Contains(command.Args, "destroy") {
+			destroyed = append(destroyed, filepath.Base(command.Dir))
+		}
+	}
+
+	if !slices.Equal(destroyed, []string{"second", "first"}) {
+		t.Errorf("Expected both modules to be destroyed in reverse order, found %v", destroyed)
+	}
+}
+
+func TestScenarioExpectationsReportMissingResources(t *testing.T) {
+	resources := SpaceResources{
+		"environments": {{"Id": "Environments-1", "Name": "Development"}},
+	}
+
+	if err := checkExpectedResources("Spaces-2", resources, map[string][]string{"environments": {"Development"}}); err != nil {
+		t.Errorf("Expected the resources to be found, found %v", err)
+	}
+
+	err := checkExpectedResources("Spaces-2", resources, map[string][]string{
+		"environments": {"Development", "Production"},
+		"projects":     {"My Project"},
+	})
+
+	if err == nil || !strings.Contains(err.Error(), "environments: Production\nprojects: My Project") {
+		t.Errorf("Expected an error listing the missing resources, found %v", err)
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test")
+	}
+
+	// A stand in for a serialiser, which exports the space by copying the module that populated it
+	exporter := func(ctx context.Context, server string, apiKey string, spaceId string, outputDir string) error {
+		return cp.Copy(filepath.Join("..", "terraform", "2-simpleexample"), outputDir)
+	}
+
+	testFramework := OctopusContainerTest{}
+	testFramework.ArrangeTest(t, func(t *testing.T, container *OctopusContainer, client *client.Client) error {
+		spaceId, err := testFramework.Act(t, container, filepath.Join("..", "terraform"), "2-simpleexample", []string{})
+		if err != nil {
+			return err
+		}
+
+		_, err = testFramework.RoundTrip(t, container, spaceId, RoundTripSettings{
+			Exporter:       exporter,
+			SpaceModuleDir: filepath.Join("..", "terraform", "1-singlespace"),
+		})
+		return err
+	})
+}
+
+func TestRoundTripDifferencesAreReported(t *testing.T) {
+	server := octofake.NewServer(ApiKey)
+	defer server.Close()
+
+	targetSpaceId, err := server.CreateSpace(context.Background(), "Round trip")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, environment := range []map[string]any{
+		{"Name": "Development", "Description": "Local builds", "UseGuidedFailure": false, "SortOrder": 1},
+		{"Name": "Test", "Description": "Integration tests", "UseGuidedFailure": false, "SortOrder": 2},
+	} {
+		server.AddResource(octofake.DefaultSpaceId, "environments", environment)
+	}
+
+	for _, environment := range []map[string]any{
+		{"Name": "Development", "Description": "Local builds", "UseGuidedFailure": true, "SortOrder": 5},
+		{"Name": "Production", "Description": "Live", "UseGuidedFailure": false, "SortOrder": 6},
+	} {
+		server.AddResource(targetSpaceId, "environments", environment)
+	}
+
+	source, err := exportSpace(server, octofake.DefaultSpaceId)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	target, err := exportSpace(server, targetSpaceId)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = compareRoundTrip(octofake.DefaultSpaceId, source, targetSpaceId, target, RoundTripSettings{IgnoreFields: []string{"SortOrder"}})
+
+	var roundTripError *RoundTripError
+	if !errors.As(err, &roundTripError) {
+		t.Fatalf("Expected a RoundTripError, found %v", err)
+	}
+
+	expectedDifferences := []string{
+		"environments/Development has a different UseGuidedFailure\n  expected: false\n  actual:   true",
+		"environments/Production was not expected",
+		"environments/Test is missing",
+	}
+
+	if !slices.Equal(roundTripError.Differences, expectedDifferences) {
+		t.Errorf("Expected the differences:\n%s\nfound:\n%s", strings.Join(expectedDifferences, "\n"), strings.Join(roundTripError.Differences, "\n"))
+	}
+
+	if err := compareRoundTrip(octofake.DefaultSpaceId, source, targetSpaceId, target, RoundTripSettings{IgnoreCollections: []string{"environments"}}); err != nil {
+		t.Errorf("Expected the spaces to match once the environments are ignored, found %v", err)
+	}
+}
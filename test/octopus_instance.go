@@ -0,0 +1,234 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/OctopusDeploy/go-octopusdeploy/v2/pkg/client"
+	"github.com/OctopusSolutionsEngineering/OctopusTerraformTestFramework/v2/octoclient"
+)
+
+/*
+	This file contains the OctopusInstance interface, which allows the same test body to run against an Octopus
+	container, an existing Octopus server, or the fake server in the octofake package.
+*/
+
+// OctopusInstance is an Octopus server that modules are applied to
+type OctopusInstance interface {
+	// GetURI returns the base URL of the server
+	GetURI() string
+	// GetApiKey returns the API key used to access the server
+	GetApiKey() string
+	// CreateSpace creates a new space managed by the administrators team, and returns the space ID
+	CreateSpace(ctx context.Context, name string) (string, error)
+	// DeleteSpace stops the task queue of a space and deletes it
+	DeleteSpace(ctx context.Context, spaceId string) error
+	// GetLogs returns the server logs
+	GetLogs(ctx context.Context) (string, error)
+	// WaitForReady waits for the API to respond
+	WaitForReady(ctx context.Context) error
+	// Shutdown stops the server. Servers that were not started by the framework are left running.
+	Shutdown(ctx context.Context) error
+}
+
+// GetURI returns the base URL of the Octopus container
+func (o *OctopusContainer) GetURI() string {
+	return o.URI
+}
+
+// GetApiKey returns the API key assigned to the admin user
+func (o *OctopusContainer) GetApiKey() string {
+	if o.apiKey == "" {
+		return ApiKey
+	}
+
+	return o.apiKey
+}
+
+func (o *OctopusContainer) CreateSpace(ctx context.Context, name string) (string, error) {
+	return createSpace(ctx, o.GetURI(), o.GetApiKey(), name)
+}
+
+func (o *OctopusContainer) DeleteSpace(ctx context.Context, spaceId string) error {
+	return deleteSpace(ctx, o.GetURI(), o.GetApiKey(), spaceId)
+}
+
+// GetLogs returns the logs written by the Octopus container
+func (o *OctopusContainer) GetLogs(ctx context.Context) (string, error) {
+	logs, err := o.Logs(ctx)
+	if err != nil {
+		return "", err
+	}
+	defer logs.Close()
+
+	content, err := io.ReadAll(logs)
+	return string(content), err
+}
+
+func (o *OctopusContainer) WaitForReady(ctx context.Context) error {
+	return waitForApi(ctx, o.GetURI())
+}
+
+// Shutdown stops and removes the Octopus container. The MSSQL container and network are removed by the
+// function that created them.
+func (o *OctopusContainer) Shutdown(ctx context.Context) error {
+	stopTime := 1 * time.Minute
+	return errors.Join(o.Stop(ctx, &stopTime), o.Terminate(ctx))
+}
+
+// ExternalOctopus is an existing Octopus server that is not managed by the framework, such as a shared
+// development instance. Tests create their own spaces, so the server can be shared by many test runs.
+type ExternalOctopus struct {
+	// URI is the base URL of the server, e.g. https://myinstance.octopus.app
+	URI string
+	// ApiKey is an API key with permission to create and delete spaces
+	ApiKey string
+}
+
+func (o *ExternalOctopus) GetURI() string {
+	return strings.TrimSuffix(o.URI, "/")
+}
+
+func (o *ExternalOctopus) GetApiKey() string {
+	return o.ApiKey
+}
+
+func (o *ExternalOctopus) CreateSpace(ctx context.Context, name string) (string, error) {
+	return createSpace(ctx, o.GetURI(), o.GetApiKey(), name)
+}
+
+func (o *ExternalOctopus) DeleteSpace(ctx context.Context, spaceId string) error {
+	return deleteSpace(ctx, o.GetURI(), o.GetApiKey(), spaceId)
+}
+
+// GetLogs returns the recent log entries reported by the server status API
+func (o *ExternalOctopus) GetLogs(ctx context.Context) (string, error) {
+	logs := []map[string]any{}
+	if err := octopusRequest(ctx, http.MethodGet, o.GetURI()+"/api/serverstatus/logs", o.GetApiKey(), nil, &logs); err != nil {
+		return "", err
+	}
+
+	lines := []string{}
+	for _, entry := range logs {
+		occurred, _ := entry["OccurredAt"].(string)
+		level, _ := entry["Level"].(string)
+		message, _ := entry["MessageText"].(string)
+		lines = append(lines, occurred+" "+level+" "+message)
+	}
+
+	return strings.Join(lines, "\n"), nil
+}
+
+func (o *ExternalOctopus) WaitForReady(ctx context.Context) error {
+	return waitForApi(ctx, o.GetURI())
+}
+
+// Shutdown does nothing, as the server is not managed by the framework
+func (o *ExternalOctopus) Shutdown(ctx context.Context) error {
+	return nil
+}
+
+// createSpace creates a new space managed by the administrators team
+func createSpace(ctx context.Context, server string, apiKey string, name string) (string, error) {
+	space := map[string]any{}
+	err := octopusRequest(ctx, http.MethodPost, server+"/api/spaces", apiKey, map[string]any{
+		"Name":               name,
+		"IsDefault":          false,
+		"TaskQueueStopped":   false,
+		"SpaceManagersTeams": []string{"teams-administrators"},
+	}, &space)
+
+	if err != nil {
+		return "", phaseError(ctx, "creating the space "+name, err)
+	}
+
+	spaceId, _ := space["Id"].(string)
+	return spaceId, nil
+}
+
+// deleteSpace stops the task queue of a space and deletes it. Octopus does not allow spaces with a running
+// task queue to be deleted.
+func deleteSpace(ctx context.Context, server string, apiKey string, spaceId string) error {
+	space := map[string]any{}
+	if err := octopusRequest(ctx, http.MethodGet, server+"/api/spaces/"+spaceId, apiKey, nil, &space); err != nil {
+		return phaseError(ctx, "reading the space "+spaceId, err)
+	}
+
+	space["TaskQueueStopped"] = true
+	if err := octopusRequest(ctx, http.MethodPut, server+"/api/spaces/"+spaceId, apiKey, space, nil); err != nil {
+		return phaseError(ctx, "stopping the task queue of space "+spaceId, err)
+	}
+
+	if err := octopusRequest(ctx, http.MethodDelete, server+"/api/spaces/"+spaceId, apiKey, nil, nil); err != nil {
+		return phaseError(ctx, "deleting the space "+spaceId, err)
+	}
+
+	return nil
+}
+
+func (o *OctopusContainerTest) getServerUrl() string {
+	if o.ServerUrl != "" {
+		return o.ServerUrl
+	}
+
+	return os.Getenv("OCTOTESTSERVERURL")
+}
+
+func (o *OctopusContainerTest) getUseFakeServer() bool {
+	return boolSetting(o.UseFakeServer, "OCTOTESTFAKESERVER", "true")
+}
+
+// ArrangeInstanceTest runs a test against the Octopus instance selected by the settings. The test is run against
+// the existing server defined by ServerUrl if it is set, the fake server if UseFakeServer is set, and a new
+// Octopus container otherwise. This allows the same test body to be run in each mode.
+func (o *OctopusContainerTest) ArrangeInstanceTest(t *testing.T, testFunc func(t *testing.T, instance OctopusInstance, client *client.Client) error) {
+	o.ArrangeInstanceTestContext(TestContext(t), t, func(ctx context.Context, t *testing.T, instance OctopusInstance, client *client.Client) error {
+		return testFunc(t, instance, client)
+	})
+}
+
+// ArrangeInstanceTestContext runs a test against the Octopus instance selected by the settings, passing the context to the test function
+func (o *OctopusContainerTest) ArrangeInstanceTestContext(ctx context.Context, t *testing.T, testFunc func(ctx context.Context, t *testing.T, instance OctopusInstance, client *client.Client) error) {
+	if serverUrl := o.getServerUrl(); serverUrl != "" {
+		o.registerSecrets()
+
+		instance := &ExternalOctopus{URI: serverUrl, ApiKey: o.GetApiKey()}
+
+		if err := instance.WaitForReady(ctx); err != nil {
+			fatalRedacted(t, err.Error())
+		}
+
+		client, err := octoclient.CreateClient(instance.GetURI(), "", instance.GetApiKey())
+		if err != nil {
+			fatalRedacted(t, err.Error())
+		}
+
+		o.setTestInstance(t, instance, nil)
+
+		o.runWithRetries(ctx, t, func() error {
+			// The server outlives the test, so the spaces created by each attempt are deleted once the modules
+			// applied to them have been destroyed
+			o.afterTest(t, func() error {
+				return o.deleteCreatedSpaces(context.WithoutCancel(ctx), t, instance)
+			})
+
+			return testFunc(ctx, t, instance, client)
+		})
+		return
+	}
+
+	if o.getUseFakeServer() {
+		o.ArrangeFakeTestContext(ctx, t, testFunc)
+		return
+	}
+
+	o.ArrangeTestContext(ctx, t, func(ctx context.Context, t *testing.T, container *OctopusContainer, client *client.Client) error {
+		return testFunc(ctx, t, container, client)
+	})
+}
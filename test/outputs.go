@@ -0,0 +1,94 @@
+package test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+)
+
+// TerraformOutput is a single output returned by "terraform output -json"
+type TerraformOutput struct {
+	// Sensitive is true if the output was marked as sensitive
+	Sensitive bool `json:"sensitive"`
+	// Type is the JSON encoded Terraform type of the output, e.g. "string" or ["list","string"]
+	Type json.RawMessage `json:"type"`
+	// Value is the JSON encoded value of the output
+	Value json.RawMessage `json:"value"`
+}
+
+// String returns the JSON encoded value of the output, masking sensitive values
+func (o TerraformOutput) String() string {
+	if o.Sensitive {
+		return "(sensitive value)"
+	}
+
+	return string(o.Value)
+}
+
+// GetAllOutputs reads every output defined by a Terraform module
+func (o *OctopusContainerTest) GetAllOutputs(t *testing.T, terraformDir string) (map[string]TerraformOutput, error) {
+	return o.GetAllOutputsContext(TestContext(t), t, terraformDir)
+}
+
+// GetAllOutputsContext reads every output defined by a Terraform module, interrupting terraform if the context is cancelled
+func (o *OctopusContainerTest) GetAllOutputsContext(ctx context.Context, t *testing.T, terraformDir string) (map[string]TerraformOutput, error) {
+	out, err := o.terraformOutputJson(ctx, t, terraformDir)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return parseOutputs(out)
+}
+
+// parseOutputs parses the result of "terraform output -json"
+func parseOutputs(out []byte) (map[string]TerraformOutput, error) {
+	outputs := map[string]TerraformOutput{}
+
+	if err := json.Unmarshal(out, &outputs); err != nil {
+		return nil, err
+	}
+
+	registerSensitiveOutputs(outputs)
+
+	return outputs, nil
+}
+
+// GetOutputAs reads a Terraform output and converts it to the requested type, for example:
+//
+//	environmentIds, err := test.GetOutputAs[map[string]string](t, &testFramework, dir, "environment_ids")
+func GetOutputAs[T any](t *testing.T, o *OctopusContainerTest, terraformDir string, outputVar string) (T, error) {
+	return GetOutputAsContext[T](TestContext(t), t, o, terraformDir, outputVar)
+}
+
+// GetOutputAsContext is the same as GetOutputAs, but interrupts terraform if the context is cancelled
+func GetOutputAsContext[T any](ctx context.Context, t *testing.T, o *OctopusContainerTest, terraformDir string, outputVar string) (T, error) {
+	var result T
+
+	outputs, err := o.GetAllOutputsContext(ctx, t, terraformDir)
+
+	if err != nil {
+		return result, err
+	}
+
+	return convertOutput[T](outputs, outputVar)
+}
+
+// convertOutput converts a single output to the requested type
+func convertOutput[T any](outputs map[string]TerraformOutput, outputVar string) (T, error) {
+	var result T
+
+	output, ok := outputs[outputVar]
+
+	if !ok {
+		return result, fmt.Errorf("the output %s was not found", outputVar)
+	}
+
+	if err := json.Unmarshal(output.Value, &result); err != nil {
+		// Don't include the value in the error, as it may be sensitive
+		return result, fmt.Errorf("the output %s of type %s could not be converted to %T: %w", outputVar, string(output.Type), result, err)
+	}
+
+	return result, nil
+}
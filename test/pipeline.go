@@ -0,0 +1,177 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"testing"
+)
+
+/*
+	This file contains the pipeline that applies a sequence of modules to an Octopus instance. Each stage can create
+	a space that later stages are applied to, and can forward its outputs to the variables of later stages, such as
+	passing the ID of a feed created by one stage to a project created by another.
+*/
+
+// PipelineStage is a single module applied by RunPipeline
+type PipelineStage struct {
+	// ModuleDir is the directory holding the module
+	ModuleDir string
+	// Vars are the variables passed to the module, as "-var=name=value" arguments or the result of TerraformVars
+	Vars []string
+	// ForwardOutputs maps the names of outputs of this module to the names of the variables they are passed to in
+	// every later stage. Forwarded values are passed in a variable file, so later stages that do not declare the
+	// variable ignore it, and the Vars of a later stage take precedence over a forwarded value.
+	ForwardOutputs map[string]string
+	// SpaceIdOutput is the output holding the ID of a space created by this module. Later stages are applied
+	// to this space. Leave empty to apply later stages to the same space as this stage.
+	SpaceIdOutput string
+}
+
+// RunPipeline applies each stage in order, starting in the Spaces-1 space, and returns the ID of the space the last
+// stage was applied to. If CheckForDrift is enabled, every module is checked for drift after it is applied, and if
+// DestroyAfterTest is enabled, the modules are destroyed in reverse order once the test function completes.
+//
+//	spaceId, err := testFramework.RunPipeline(t, container, []test.PipelineStage{
+//		{ModuleDir: "../terraform/1-singlespace", Vars: []string{"-var=octopus_space_name=Test"}, SpaceIdOutput: "octopus_space_id"},
+//		{ModuleDir: "../terraform/feeds", ForwardOutputs: map[string]string{"feed_id": "docker_feed_id"}},
+//		{ModuleDir: "../terraform/environments"},
+//		{ModuleDir: "../terraform/projects"},
+//	})
+func (o *OctopusContainerTest) RunPipeline(t *testing.T, container OctopusInstance, stages []PipelineStage) (string, error) {
+	return o.RunPipelineContext(TestContext(t), t, container, stages)
+}
+
+// RunPipelineContext is the same as RunPipeline, but interrupts terraform if the context is cancelled
+func (o *OctopusContainerTest) RunPipelineContext(ctx context.Context, t *testing.T, container OctopusInstance, stages []PipelineStage) (string, error) {
+	path, err := os.Getwd()
+	if err != nil {
+		return "", err
+	}
+	logRedacted(t, "Working dir: "+path)
+
+	executor := o.getExecutor()
+	if version, err := executor.Version(ctx); err == nil {
+		logRedacted(t, "Using "+executor.Name()+" "+version)
+	} else {
+		logRedacted(t, "Failed to read the "+executor.Name()+" version: "+err.Error())
+	}
+
+	spaceId := "Spaces-1"
+
+	// When enabled, the modules are destroyed in reverse order once the test function completes
+	appliedModules := []appliedModule{}
+	if o.getDestroyAfterTest() {
+		o.afterTest(t, func() error {
+			return o.destroyModules(ctx, t, container.GetURI(), appliedModules, spaceId)
+		})
+	}
+
+	// The outputs forwarded by earlier stages, keyed by the name of the variable they are passed to
+	forwardedVars := map[string]any{}
+
+	for _, stage := range stages {
+		// Every module is applied from its own copy, leaving the source tree untouched
+		terraformProjectDir, err := o.createWorkingCopy(t, stage.ModuleDir)
+		if err != nil {
+			return "", err
+		}
+
+		o.cleanTerraformModule(terraformProjectDir)
+
+		if !o.getSkipInit() {
+			err := o.TerraformInitContext(ctx, t, terraformProjectDir)
+
+			if err != nil {
+				return "", err
+			}
+		}
+
+		vars := stage.Vars
+		if len(forwardedVars) != 0 {
+			forwardedArgs, err := o.TerraformVars(t, forwardedVars)
+			if err != nil {
+				return "", err
+			}
+
+			// Later arguments take precedence, so the variables of the stage override forwarded values
+			vars = append(forwardedArgs, stage.Vars...)
+		}
+
+		o.waitForSpace(ctx, t, container.GetURI(), spaceId)
+
+		err = o.TerraformApplyContext(ctx, t, terraformProjectDir, container.GetURI(), spaceId, vars)
+
+		// Partially applied modules must also be destroyed
+		appliedModules = append(appliedModules, appliedModule{dir: terraformProjectDir, spaceId: spaceId, vars: vars})
+
+		if err != nil {
+			return "", err
+		}
+
+		if o.getCheckForDrift() {
+			err = o.AssertNoDriftContext(ctx, t, terraformProjectDir, container.GetURI(), spaceId, vars)
+
+			if err != nil {
+				return "", err
+			}
+		}
+
+		if len(stage.ForwardOutputs) != 0 {
+			outputs, err := o.GetAllOutputsContext(ctx, t, terraformProjectDir)
+			if err != nil {
+				return "", err
+			}
+
+			if err := forwardOutputs(outputs, stage.ForwardOutputs, forwardedVars); err != nil {
+				return "", fmt.Errorf("failed to forward the outputs of %s: %w", stage.ModuleDir, err)
+			}
+		}
+
+		// get the ID of any new space created, which will be used in the subsequent Terraform executions
+		if stage.SpaceIdOutput != "" {
+			spaceId, err = o.GetOutputVariableContext(ctx, t, terraformProjectDir, stage.SpaceIdOutput)
+			if err != nil || len(strings.TrimSpace(spaceId)) == 0 {
+				// I've seen number of tests fail because the state file is blank and there is no output to read.
+				// We offer a workaround for this by setting the default space ID, which is usually Spaces-2
+				if o.getDefaultSpaceId() != "" {
+					spaceId = o.getDefaultSpaceId()
+				} else if err != nil {
+					return "", err
+				} else {
+					return "", errors.New("the output " + stage.SpaceIdOutput + " of " + stage.ModuleDir + " was empty")
+				}
+			} else {
+				o.recordCreatedSpace(t, spaceId)
+			}
+		}
+	}
+
+	return spaceId, nil
+}
+
+// forwardOutputs adds the outputs mapped by forward to the forwarded variables. The values keep their Terraform
+// types, so lists and maps can be forwarded. Every mapped output must exist.
+func forwardOutputs(outputs map[string]TerraformOutput, forward map[string]string, forwardedVars map[string]any) error {
+	outputNames := make([]string, 0, len(forward))
+	for outputName := range forward {
+		outputNames = append(outputNames, outputName)
+	}
+	sort.Strings(outputNames)
+
+	var errs []error
+	for _, outputName := range outputNames {
+		output, ok := outputs[outputName]
+		if !ok {
+			errs = append(errs, fmt.Errorf("the output %s was not found", outputName))
+			continue
+		}
+
+		forwardedVars[forward[outputName]] = output.Value
+	}
+
+	return errors.Join(errs...)
+}
@@ -0,0 +1,187 @@
+package test
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclparse"
+	tclog "github.com/testcontainers/testcontainers-go/log"
+	"github.com/zclconf/go-cty/cty"
+)
+
+/*
+	This file contains the redaction applied to everything the framework writes to the test output, the container
+	logs, and the failure artifacts. The API key, license, and default password are registered automatically, as
+	are the values of sensitive Terraform outputs and the values passed to sensitive Terraform variables.
+*/
+
+// redactedValue replaces each secret
+const redactedValue = "[REDACTED]"
+
+// minSecretLength is the length of the shortest secret that is redacted. Shorter values, such as "true" passed
+// to a sensitive variable, would mask unrelated text.
+const minSecretLength = 6
+
+// variableSchema selects the variable blocks of a module
+var variableSchema = &hcl.BodySchema{
+	Blocks: []hcl.BlockHeaderSchema{{Type: "variable", LabelNames: []string{"name"}}},
+}
+
+// sensitiveSchema selects the sensitive attribute of a variable block
+var sensitiveSchema = &hcl.BodySchema{
+	Attributes: []hcl.AttributeSchema{{Name: "sensitive"}},
+}
+
+var secrets = []string{defaultPassword}
+var secretsMutex = sync.RWMutex{}
+
+// RegisterSecret adds a value that is masked in the test output, container logs, and failure artifacts written by the
+// framework. Values shorter than 6 characters are ignored.
+func RegisterSecret(secret string) {
+	if len(secret) < minSecretLength {
+		return
+	}
+
+	secretsMutex.Lock()
+	defer secretsMutex.Unlock()
+
+	for _, existing := range secrets {
+		if existing == secret {
+			return
+		}
+	}
+
+	secrets = append(secrets, secret)
+
+	// Longer secrets are replaced first, so a secret containing another secret is completely masked
+	sort.SliceStable(secrets, func(i, j int) bool {
+		return len(secrets[i]) > len(secrets[j])
+	})
+}
+
+// Redact masks every registered secret in the text
+func Redact(text string) string {
+	secretsMutex.RLock()
+	defer secretsMutex.RUnlock()
+
+	for _, secret := range secrets {
+		text = strings.ReplaceAll(text, secret, redactedValue)
+	}
+
+	return text
+}
+
+// registerSecrets registers the secrets defined by the settings
+func (o *OctopusContainerTest) registerSecrets() {
+	RegisterSecret(o.GetApiKey())
+	RegisterSecret(o.getLicense())
+}
+
+// registerSensitiveVariables registers the values passed to variables declared as sensitive by the module, either as
+// "-var=name=value" arguments or in variable files
+func registerSensitiveVariables(terraformProjectDir string, vars []string) {
+	sensitive := sensitiveVariables(terraformProjectDir)
+
+	for name, value := range readVarArgs(vars) {
+		if sensitive[name] {
+			RegisterSecret(value)
+		}
+	}
+}
+
+// sensitiveVariables returns the names of the variables declared with "sensitive = true" by the module. Files that
+// can not be parsed are skipped, as terraform reports the errors when the module is applied.
+func sensitiveVariables(terraformProjectDir string) map[string]bool {
+	sensitive := map[string]bool{}
+	parser := hclparse.NewParser()
+
+	for _, pattern := range []string{"*.tf", "*.tf.json"} {
+		files, err := filepath.Glob(filepath.Join(terraformProjectDir, pattern))
+		if err != nil {
+			continue
+		}
+
+		for _, file := range files {
+			var parsed *hcl.File
+			if strings.HasSuffix(file, ".json") {
+				parsed, _ = parser.ParseJSONFile(file)
+			} else {
+				parsed, _ = parser.ParseHCLFile(file)
+			}
+
+			if parsed == nil {
+				continue
+			}
+
+			content, _, _ := parsed.Body.PartialContent(variableSchema)
+			for _, block := range content.Blocks {
+				attributes, _, _ := block.Body.PartialContent(sensitiveSchema)
+				attribute, ok := attributes.Attributes["sensitive"]
+				if !ok {
+					continue
+				}
+
+				value, diags := attribute.Expr.Value(nil)
+				if !diags.HasErrors() && value.Type() == cty.Bool && value.IsKnown() && !value.IsNull() && value.True() {
+					sensitive[block.Labels[0]] = true
+				}
+			}
+		}
+	}
+
+	return sensitive
+}
+
+// registerSensitiveOutputs registers the values of outputs marked as sensitive
+func registerSensitiveOutputs(outputs map[string]TerraformOutput) {
+	for _, output := range outputs {
+		if !output.Sensitive {
+			continue
+		}
+
+		var value string
+		if err := json.Unmarshal(output.Value, &value); err == nil {
+			RegisterSecret(value)
+		} else {
+			RegisterSecret(string(output.Value))
+		}
+	}
+}
+
+// logRedacted logs the arguments like t.Log, masking any secrets
+func logRedacted(t testing.TB, args ...any) {
+	t.Helper()
+	t.Log(Redact(strings.TrimSuffix(fmt.Sprintln(args...), "\n")))
+}
+
+// logRedactedf logs the arguments like t.Logf, masking any secrets
+func logRedactedf(t testing.TB, format string, args ...any) {
+	t.Helper()
+	t.Log(Redact(fmt.Sprintf(format, args...)))
+}
+
+// fatalRedacted fails the test like t.Fatal, masking any secrets
+func fatalRedacted(t testing.TB, args ...any) {
+	t.Helper()
+	t.Fatal(Redact(strings.TrimSuffix(fmt.Sprintln(args...), "\n")))
+}
+
+// errorRedacted fails the test like t.Error, masking any secrets
+func errorRedacted(t testing.TB, args ...any) {
+	t.Helper()
+	t.Error(Redact(strings.TrimSuffix(fmt.Sprintln(args...), "\n")))
+}
+
+// redactingLogger masks secrets in the messages logged by testcontainers, such as the environment of a
+// container that failed to start
+type redactingLogger struct{}
+
+func (redactingLogger) Printf(format string, v ...any) {
+	tclog.Default().Printf("%s", Redact(fmt.Sprintf(format, v...)))
+}
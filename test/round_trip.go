@@ -0,0 +1,138 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/OctopusSolutionsEngineering/OctopusTerraformTestFramework/v2/octoclient"
+	"github.com/OctopusSolutionsEngineering/OctopusTerraformTestFramework/v2/spaceexport"
+)
+
+/*
+	This file contains the round trip harness used to test serialisers that export an Octopus space to a Terraform
+	module. The exported module is applied to a new space, and the two spaces are compared to find any resource or
+	field that did not survive the round trip.
+*/
+
+// SpaceExporter writes a Terraform module that recreates the resources in a space to the output directory, such as
+// by running the serialiser under test. The module is applied to a new space with the same variables as any other
+// module, so it must declare the server, API key, and space ID variables.
+type SpaceExporter func(ctx context.Context, server string, apiKey string, spaceId string, outputDir string) error
+
+// RoundTripSettings configures RoundTrip
+type RoundTripSettings struct {
+	// Exporter writes the module that recreates the space
+	Exporter SpaceExporter
+	// SpaceModuleDir is the directory holding the module that creates the space the exported module is applied to
+	SpaceModuleDir string
+	// SpaceVars are the variables passed to the module that creates the space
+	SpaceVars []string
+	// Vars are the variables passed to the exported module, such as the values of sensitive variables that can
+	// not be read from the API
+	Vars []string
+	// IgnoreCollections are the API collections, such as "machines", that are not compared
+	IgnoreCollections []string
+	// IgnoreFields are the names of fields that are not compared, at any depth, such as fields the exporter is known
+	// not to support
+	IgnoreFields []string
+}
+
+// RoundTripError is returned when the space recreated from an exported module does not match the original space
+type RoundTripError struct {
+	SourceSpaceId string
+	TargetSpaceId string
+	Differences   []string
+}
+
+func (e *RoundTripError) Error() string {
+	return "space " + e.TargetSpaceId + " does not match space " + e.SourceSpaceId + " after the round trip:\n" +
+		strings.Join(e.Differences, "\n")
+}
+
+// RoundTrip exports the space with the exporter, applies the exported module to a new space with ActWithCustomSpace,
+// and returns a RoundTripError describing every resource or field that is different in the new space. The ID of the
+// new space is returned, so the test can make further assertions about it.
+//
+//	spaceId, err := testFramework.Act(t, container, "../terraform", "2-simpleexample", []string{})
+//	if err != nil {
+//		return err
+//	}
+//
+//	_, err = testFramework.RoundTrip(t, container, spaceId, test.RoundTripSettings{
+//		Exporter:       exportWithSerialiser,
+//		SpaceModuleDir: "../terraform/1-singlespace",
+//	})
+//	return err
+func (o *OctopusContainerTest) RoundTrip(t *testing.T, container OctopusInstance, spaceId string, settings RoundTripSettings) (string, error) {
+	return o.RoundTripContext(TestContext(t), t, container, spaceId, settings)
+}
+
+// RoundTripContext is the same as RoundTrip, but interrupts terraform if the context is cancelled
+func (o *OctopusContainerTest) RoundTripContext(ctx context.Context, t *testing.T, container OctopusInstance, spaceId string, settings RoundTripSettings) (string, error) {
+	if settings.Exporter == nil {
+		return "", errors.New("the round trip requires an exporter")
+	}
+
+	if settings.SpaceModuleDir == "" {
+		return "", errors.New("the round trip requires the directory of the module that creates the new space")
+	}
+
+	// The source space is read before the exported module is applied, in case the exporter modifies it
+	source, err := exportSpace(container, spaceId)
+	if err != nil {
+		return "", err
+	}
+
+	moduleDir := filepath.Join(t.TempDir(), "exported")
+	logRedacted(t, "Exporting space "+spaceId+" to "+moduleDir)
+
+	if err := settings.Exporter(ctx, container.GetURI(), container.GetApiKey(), spaceId, moduleDir); err != nil {
+		return "", phaseError(ctx, "exporting space "+spaceId, err)
+	}
+
+	targetSpaceId, err := o.ActWithCustomSpaceContext(ctx, t, container, settings.SpaceModuleDir, moduleDir, settings.SpaceVars, settings.Vars)
+	if err != nil {
+		return "", err
+	}
+
+	target, err := exportSpace(container, targetSpaceId)
+	if err != nil {
+		return targetSpaceId, err
+	}
+
+	return targetSpaceId, compareRoundTrip(spaceId, source, targetSpaceId, target, settings)
+}
+
+// exportSpace reads and normalises every resource in the space
+func exportSpace(container OctopusInstance, spaceId string) (spaceexport.Space, error) {
+	client, err := octoclient.CreateClient(container.GetURI(), spaceId, container.GetApiKey())
+	if err != nil {
+		return nil, err
+	}
+
+	space, err := spaceexport.Export(client, spaceId)
+	if err != nil {
+		return nil, fmt.Errorf("failed to export space %s: %w", spaceId, err)
+	}
+
+	return spaceexport.Normalise(space), nil
+}
+
+// compareRoundTrip returns a RoundTripError if the normalised exports of the two spaces are different
+func compareRoundTrip(sourceSpaceId string, source spaceexport.Space, targetSpaceId string, target spaceexport.Space, settings RoundTripSettings) error {
+	for _, collection := range settings.IgnoreCollections {
+		delete(source, collection)
+		delete(target, collection)
+	}
+
+	differences := spaceexport.Compare(source, target, settings.IgnoreFields...)
+	if len(differences) == 0 {
+		return nil
+	}
+
+	return &RoundTripError{SourceSpaceId: sourceSpaceId, TargetSpaceId: targetSpaceId, Differences: differences}
+}
@@ -0,0 +1,221 @@
+package test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/OctopusDeploy/go-octopusdeploy/v2/pkg/client"
+	"gopkg.in/yaml.v3"
+)
+
+/*
+	This file contains the runner for scenario files. A scenario describes a test that creates a space, applies a
+	module to it, and checks the resources that were created, allowing tests of this shape to be added without
+	writing Go code.
+*/
+
+// scenarioExtensions are the extensions of the files loaded by RunScenarios
+var scenarioExtensions = []string{".yaml", ".yml", ".json"}
+
+// Scenario is a test defined in a YAML or JSON file, for example:
+//
+//	name: Simple example
+//	module: ../terraform/2-simpleexample
+//	spaceVars:
+//	  octopus_space_description: Created by a scenario
+//	expect:
+//	  environments: [Development, Test, Production]
+type Scenario struct {
+	// Name is the name of the subtest. Defaults to the name of the file without the extension.
+	Name string `yaml:"name"`
+	// Module is the directory holding the module under test, relative to the scenario file
+	Module string `yaml:"module"`
+	// Vars are the variables passed to the module
+	Vars map[string]any `yaml:"vars"`
+	// SpaceModule is the directory holding the module that creates the space, relative to the scenario file.
+	// Defaults to the 1-singlespace directory next to the module under test.
+	SpaceModule string `yaml:"spaceModule"`
+	// SpaceVars are the variables passed to the module that creates the space
+	SpaceVars map[string]any `yaml:"spaceVars"`
+	// Expect maps an API collection, such as "environments" or "projects", to the names of the resources that must
+	// exist in the space once the module is applied
+	Expect map[string][]string `yaml:"expect"`
+
+	// file is the scenario file, used to resolve the module directories
+	file string
+}
+
+// RunScenarios runs every scenario file in the directory as a subtest. Each scenario is arranged with ArrangeTest,
+// so it is retried and uses a shared stack or stack pool like any other test.
+func (o *OctopusContainerTest) RunScenarios(t *testing.T, dir string) {
+	o.RunScenariosContext(TestContext(t), t, dir)
+}
+
+// RunScenariosContext is the same as RunScenarios, but interrupts terraform if the context is cancelled
+func (o *OctopusContainerTest) RunScenariosContext(ctx context.Context, t *testing.T, dir string) {
+	files, err := findScenarioFiles(dir)
+	if err != nil {
+		fatalRedacted(t, err)
+	}
+
+	if len(files) == 0 {
+		fatalRedacted(t, "No scenario files were found in "+dir)
+	}
+
+	for _, file := range files {
+		scenario, err := loadScenario(file)
+
+		name := strings.TrimSuffix(filepath.Base(file), filepath.Ext(file))
+		if err == nil && scenario.Name != "" {
+			name = scenario.Name
+		}
+
+		t.Run(name, func(t *testing.T) {
+			// An invalid file fails its own subtest, so the other scenarios still run
+			if err != nil {
+				fatalRedacted(t, err)
+			}
+
+			o.ArrangeTestContext(ctx, t, func(ctx context.Context, t *testing.T, container *OctopusContainer, client *client.Client) error {
+				return o.runScenario(ctx, t, container, scenario)
+			})
+		})
+	}
+}
+
+// runScenario applies the module described by the scenario and checks the expected resources exist
+func (o *OctopusContainerTest) runScenario(ctx context.Context, t *testing.T, container OctopusInstance, scenario Scenario) error {
+	vars, err := o.scenarioVars(t, scenario.Vars)
+	if err != nil {
+		return err
+	}
+
+	spaceVars, err := o.scenarioVars(t, scenario.SpaceVars)
+	if err != nil {
+		return err
+	}
+
+	baseDir := filepath.Dir(scenario.file)
+	moduleDir := filepath.Join(baseDir, scenario.Module)
+	spaceModuleDir := filepath.Join(filepath.Dir(moduleDir), "1-singlespace")
+	if scenario.SpaceModule != "" {
+		spaceModuleDir = filepath.Join(baseDir, scenario.SpaceModule)
+	}
+
+	spaceId, err := o.ActWithCustomSpaceContext(ctx, t, container, spaceModuleDir, moduleDir, spaceVars, vars)
+	if err != nil {
+		return err
+	}
+
+	if len(scenario.Expect) == 0 {
+		return nil
+	}
+
+	resources, err := o.getSpaceResources(ctx, container.GetURI(), spaceId, true)
+	if err != nil {
+		return err
+	}
+
+	return checkExpectedResources(spaceId, resources, scenario.Expect)
+}
+
+// scenarioVars returns the arguments passing the variables of a scenario in a variable file
+func (o *OctopusContainerTest) scenarioVars(t *testing.T, vars map[string]any) ([]string, error) {
+	if len(vars) == 0 {
+		return []string{}, nil
+	}
+
+	return o.TerraformVars(t, vars)
+}
+
+// findScenarioFiles returns the scenario files in the directory, sorted by name
+func findScenarioFiles(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	files := []string{}
+	for _, entry := range entries {
+		if !entry.IsDir() && slices.Contains(scenarioExtensions, strings.ToLower(filepath.Ext(entry.Name()))) {
+			files = append(files, filepath.Join(dir, entry.Name()))
+		}
+	}
+	sort.Strings(files)
+
+	return files, nil
+}
+
+// loadScenario reads and validates a scenario file. JSON is parsed as YAML, which it is a subset of. Unknown
+// fields are rejected, so a misspelt field fails the scenario rather than being ignored.
+func loadScenario(file string) (Scenario, error) {
+	scenario := Scenario{}
+
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return scenario, err
+	}
+
+	decoder := yaml.NewDecoder(bytes.NewReader(content))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&scenario); err != nil {
+		return scenario, fmt.Errorf("failed to parse the scenario %s: %w", file, err)
+	}
+
+	scenario.file = file
+
+	var errs []error
+	if scenario.Module == "" {
+		errs = append(errs, errors.New("the module field is required"))
+	}
+
+	for collection := range scenario.Expect {
+		if !slices.Contains(spaceCollections, collection) {
+			errs = append(errs, fmt.Errorf("the expected collection %s is not one of %s", collection, strings.Join(spaceCollections, ", ")))
+		}
+	}
+
+	if err := errors.Join(errs...); err != nil {
+		return scenario, fmt.Errorf("the scenario %s is invalid: %w", file, err)
+	}
+
+	return scenario, nil
+}
+
+// checkExpectedResources returns an error listing every expected resource that was not found in the space
+func checkExpectedResources(spaceId string, resources SpaceResources, expect map[string][]string) error {
+	collections := make([]string, 0, len(expect))
+	for collection := range expect {
+		collections = append(collections, collection)
+	}
+	sort.Strings(collections)
+
+	missing := []string{}
+	for _, collection := range collections {
+		names := []string{}
+		for _, resource := range resources[collection] {
+			name, _ := resource["Name"].(string)
+			names = append(names, name)
+		}
+
+		for _, expected := range expect[collection] {
+			if !slices.Contains(names, expected) {
+				missing = append(missing, collection+": "+expected)
+			}
+		}
+	}
+
+	if len(missing) != 0 {
+		return errors.New("the expected resources were not found in space " + spaceId + ":\n" + strings.Join(missing, "\n"))
+	}
+
+	return nil
+}
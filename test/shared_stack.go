@@ -0,0 +1,184 @@
+package test
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"runtime/debug"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/OctopusDeploy/go-octopusdeploy/v2/pkg/client"
+	"github.com/avast/retry-go/v4"
+	"github.com/testcontainers/testcontainers-go"
+)
+
+/*
+	This file contains functions that allow a single Octopus stack to be shared by all the tests in a package.
+	Booting a stack takes minutes, so packages with many tests can start one stack in TestMain and have
+	every call to ArrangeTest reuse it. Each test still gets its own space via the Act functions.
+*/
+
+// OctopusStack holds the network, MSSQL container, and Octopus container that make up a running Octopus instance
+type OctopusStack struct {
+	Container *OctopusContainer
+	Client    *client.Client
+	SqlServer *MysqlContainer
+	Network   testcontainers.Network
+}
+
+var sharedStack *OctopusStack
+var sharedStackMutex = sync.Mutex{}
+
+// getSharedStack returns the shared stack, or nil if no shared stack has been started
+func getSharedStack() *OctopusStack {
+	sharedStackMutex.Lock()
+	defer sharedStackMutex.Unlock()
+
+	return sharedStack
+}
+
+// StartSharedStack creates an Octopus stack that is used by every call to ArrangeTest until StopSharedStack is called.
+// Calling StartSharedStack when a shared stack is already running returns the existing stack.
+func (o *OctopusContainerTest) StartSharedStack() (*OctopusStack, error) {
+	return o.StartSharedStackContext(context.Background())
+}
+
+// StartSharedStackContext is the same as StartSharedStack, but abandons creating the stack if the context is cancelled.
+func (o *OctopusContainerTest) StartSharedStackContext(ctx context.Context) (*OctopusStack, error) {
+	sharedStackMutex.Lock()
+	defer sharedStackMutex.Unlock()
+
+	if sharedStack != nil {
+		return sharedStack, nil
+	}
+
+	octopusContainer, octoClient, sqlServer, network, err := o.ArrangeContainerContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	sharedStack = &OctopusStack{
+		Container: octopusContainer,
+		Client:    octoClient,
+		SqlServer: sqlServer,
+		Network:   network,
+	}
+
+	return sharedStack, nil
+}
+
+// StopSharedStack removes the containers and network created by StartSharedStack. It is safe to call
+// StopSharedStack when no shared stack is running.
+func (o *OctopusContainerTest) StopSharedStack() error {
+	sharedStackMutex.Lock()
+	defer sharedStackMutex.Unlock()
+
+	if sharedStack == nil {
+		return nil
+	}
+
+	// This fixes the "can not get logs from container which is dead or marked for removal" error
+	// See https://github.com/testcontainers/testcontainers-go/issues/606
+	if sharedStack.Container.logConsumer != nil {
+		if err := sharedStack.Container.StopLogProducer(); err != nil {
+			log.Println(err)
+		}
+	}
+
+	if sharedStack.SqlServer.logConsumer != nil {
+		if err := sharedStack.SqlServer.StopLogProducer(); err != nil {
+			log.Println(err)
+		}
+	}
+
+	err := o.CleanUp(context.Background(), sharedStack.Container, sharedStack.SqlServer, sharedStack.Network)
+	sharedStack = nil
+
+	return err
+}
+
+// RunWithSharedStack is designed to be called from TestMain. It starts a shared stack, runs the tests,
+// and removes the shared stack. The return value is the exit code to pass to os.Exit.
+//
+//	func TestMain(m *testing.M) {
+//		testFramework := test.OctopusContainerTest{}
+//		os.Exit(testFramework.RunWithSharedStack(m))
+//	}
+func (o *OctopusContainerTest) RunWithSharedStack(m *testing.M) int {
+	// TestMain must parse the flags itself before testing.Short() can be called
+	if !flag.Parsed() {
+		flag.Parse()
+	}
+
+	// Short tests skip the integration tests, so there is no need for a stack
+	if testing.Short() {
+		return m.Run()
+	}
+
+	if _, err := o.StartSharedStack(); err != nil {
+		log.Println("Failed to start the shared stack: " + err.Error())
+		return 1
+	}
+
+	// A panic in a test ends the process from the test's goroutine, so the deferred function does not run.
+	// The testcontainers reaper removes the containers in that case.
+	defer func() {
+		if err := o.StopSharedStack(); err != nil {
+			log.Println("Failed to stop the shared stack: " + err.Error())
+		}
+
+		DeleteDatabaseSnapshots()
+	}()
+
+	return m.Run()
+}
+
+// arrangeSharedTest runs a test against the shared stack
+func (o *OctopusContainerTest) arrangeSharedTest(ctx context.Context, t *testing.T, stack *OctopusStack, testFunc func(ctx context.Context, t *testing.T, container *OctopusContainer, client *client.Client) error) {
+	if testing.Short() {
+		t.Skip("skipping integration test")
+	}
+
+	o.setTestInstance(t, stack.Container, stack.SqlServer)
+
+	// Parallel tests overlap on the shared stack, so lines are routed to the most recently started test
+	routeContainerLogs(t, stack.Container, stack.SqlServer)
+
+	o.runWithRetries(ctx, t, func() error {
+		return testFunc(ctx, t, stack.Container, stack.Client)
+	})
+}
+
+// runWithRetries runs a test against an Octopus instance that outlives the test, such as the shared stack.
+// Panics in the test function are converted to test failures so one misbehaving test does not prevent the
+// instance from being cleaned up.
+func (o *OctopusContainerTest) runWithRetries(ctx context.Context, t *testing.T, testFunc func() error) {
+	err := retry.Do(
+		func() (err error) {
+			defer func() {
+				if r := recover(); r != nil {
+					err = retry.Unrecoverable(fmt.Errorf("test panicked: %v\n%s", r, debug.Stack()))
+				}
+			}()
+
+			err = o.runTestFunc(ctx, t, testFunc)
+
+			if err != nil {
+				logRedacted(t, err.Error())
+			}
+
+			return err
+		},
+		retry.Attempts(o.getRetryCount()),
+		retry.Delay(30*time.Second),
+		retry.Context(ctx),
+		retry.RetryIf(isRetryable),
+	)
+
+	if err != nil {
+		fatalRedacted(t, err.Error())
+	}
+}
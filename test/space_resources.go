@@ -0,0 +1,197 @@
+package test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/OctopusSolutionsEngineering/OctopusTerraformTestFramework/v2/spaceexport"
+)
+
+// spaceCollections are the API collections that hold the resources in a space
+var spaceCollections = spaceexport.Collections
+
+// defaultResourceNames are the resources Octopus creates in every new space
+var defaultResourceNames = map[string][]string{
+	"lifecycles":      {"Default Lifecycle"},
+	"machinepolicies": {"Default Machine Policy"},
+	"projectgroups":   {"Default Project Group"},
+	"workerpools":     {"Default Worker Pool", "Hosted Ubuntu", "Hosted Windows"},
+}
+
+// defaultFeedTypes are the feed types Octopus creates in every new space
+var defaultFeedTypes = []string{"BuiltIn", "OctopusProject"}
+
+// errNotFound is returned when the API responds with a 404
+var errNotFound = errors.New("the resource was not found")
+
+// SpaceResources maps an API collection, such as "environments", to the resources it holds
+type SpaceResources map[string][]map[string]any
+
+// LeftoverResourcesError is returned when resources remain in a space after the Terraform modules were destroyed
+type LeftoverResourcesError struct {
+	SpaceId   string
+	Resources SpaceResources
+}
+
+func (e *LeftoverResourcesError) Error() string {
+	collections := make([]string, 0, len(e.Resources))
+	for collection := range e.Resources {
+		collections = append(collections, collection)
+	}
+	sort.Strings(collections)
+
+	leftovers := []string{}
+	for _, collection := range collections {
+		names := []string{}
+		for _, resource := range e.Resources[collection] {
+			names = append(names, resourceLabel(resource))
+		}
+		leftovers = append(leftovers, collection+": "+strings.Join(names, ", "))
+	}
+
+	return "resources were left in space " + e.SpaceId + " after the modules were destroyed:\n" + strings.Join(leftovers, "\n")
+}
+
+// resourceLabel returns a human readable identifier for a resource
+func resourceLabel(resource map[string]any) string {
+	name, _ := resource["Name"].(string)
+	id, _ := resource["Id"].(string)
+
+	if name == "" {
+		return id
+	}
+
+	return name + " (" + id + ")"
+}
+
+// isDefaultResource returns true if the resource is created by Octopus in every new space
+func isDefaultResource(collection string, resource map[string]any) bool {
+	if collection == "feeds" {
+		feedType, _ := resource["FeedType"].(string)
+		return slices.Contains(defaultFeedTypes, feedType)
+	}
+
+	name, _ := resource["Name"].(string)
+	return slices.Contains(defaultResourceNames[collection], name)
+}
+
+// getSpaceResources returns every resource in the space, optionally excluding the resources Octopus creates in every new space.
+// errNotFound is returned if the space does not exist.
+func (o *OctopusContainerTest) getSpaceResources(ctx context.Context, server string, spaceId string, includeDefaults bool) (SpaceResources, error) {
+	if err := o.getOctopusJson(ctx, server+"/api/spaces/"+spaceId, &map[string]any{}); err != nil {
+		return nil, err
+	}
+
+	result := SpaceResources{}
+	for _, collection := range spaceCollections {
+		path := "/api/" + spaceId + "/" + collection + "?skip=0&take=1000"
+
+		for path != "" {
+			page := struct {
+				Items []map[string]any
+				Links map[string]string
+			}{}
+
+			err := o.getOctopusJson(ctx, server+path, &page)
+
+			// Older versions of Octopus do not support every collection
+			if errors.Is(err, errNotFound) {
+				break
+			}
+
+			if err != nil {
+				return nil, fmt.Errorf("failed to read the %s collection: %w", collection, err)
+			}
+
+			for _, item := range page.Items {
+				if includeDefaults || !isDefaultResource(collection, item) {
+					result[collection] = append(result[collection], item)
+				}
+			}
+
+			path = page.Links["Page.Next"]
+		}
+	}
+
+	return result, nil
+}
+
+// getOctopusJson reads a JSON document from the Octopus API
+func (o *OctopusContainerTest) getOctopusJson(ctx context.Context, url string, result any) error {
+	return octopusRequest(ctx, http.MethodGet, url, o.GetApiKey(), nil, result)
+}
+
+// octopusRequest sends a request to the Octopus API, decoding the JSON response into the result if it is not nil
+func octopusRequest(ctx context.Context, method string, url string, apiKey string, body any, result any) error {
+	var requestBody io.Reader
+	if body != nil {
+		requestJson, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		requestBody = bytes.NewReader(requestJson)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, url, requestBody)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("X-Octopus-ApiKey", apiKey)
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	response, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode == http.StatusNotFound {
+		return errNotFound
+	}
+
+	responseBody, err := io.ReadAll(response.Body)
+	if err != nil {
+		return err
+	}
+
+	if !(response.StatusCode >= 200 && response.StatusCode <= 299) {
+		return fmt.Errorf("%s %s returned status code %d: %s", method, url, response.StatusCode, string(responseBody))
+	}
+
+	if result == nil {
+		return nil
+	}
+
+	return json.Unmarshal(responseBody, result)
+}
+
+// verifySpaceIsEmpty returns a LeftoverResourcesError if the space still exists and holds any resources
+// other than those Octopus creates in every new space
+func (o *OctopusContainerTest) verifySpaceIsEmpty(ctx context.Context, server string, spaceId string) error {
+	resources, err := o.getSpaceResources(ctx, server, spaceId, false)
+
+	if errors.Is(err, errNotFound) {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if len(resources) != 0 {
+		return &LeftoverResourcesError{SpaceId: spaceId, Resources: resources}
+	}
+
+	return nil
+}
@@ -0,0 +1,524 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"log"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/OctopusDeploy/go-octopusdeploy/v2/pkg/client"
+	"github.com/OctopusSolutionsEngineering/OctopusTerraformTestFramework/v2/octoclient"
+	"github.com/OctopusSolutionsEngineering/OctopusTerraformTestFramework/v2/spaceexport"
+)
+
+/*
+	This file contains a pool of Octopus stacks that are started in the background and leased to each call to
+	ArrangeTest. A test using the pool only waits for a stack to boot if every stack in the pool is leased.
+	Once a test completes, the spaces it created are deleted and the stack is returned to the pool. Stacks that
+	are unhealthy, can not be reset, were changed outside the test's spaces, or have exceeded their maximum lifetime
+	are removed and replaced. Every stack in the pool counts towards the limit on the number of running stacks.
+*/
+
+// defaultStackPoolMaxLifetime is the time a stack is used for before it is replaced
+const defaultStackPoolMaxLifetime = 1 * time.Hour
+
+// stackHealthCheckTimeout is the time a stack has to respond before it is considered unhealthy
+const stackHealthCheckTimeout = 30 * time.Second
+
+// StackPool keeps a number of Octopus stacks running, and leases them to tests
+type StackPool struct {
+	testFramework *OctopusContainerTest
+	maxLifetime   time.Duration
+	// ctx is cancelled when the pool is stopped, abandoning any stacks being created
+	ctx    context.Context
+	cancel context.CancelFunc
+	// idle holds the stacks that are ready to be leased
+	idle chan *pooledStack
+	// creating tracks the goroutines creating stacks
+	creating sync.WaitGroup
+	mutex    sync.Mutex
+	// stacks holds every stack created by the pool that has not been removed
+	stacks map[*pooledStack]bool
+}
+
+// pooledStack is a stack managed by a StackPool
+type pooledStack struct {
+	OctopusStack
+	created time.Time
+	// spaceIds are the spaces that existed when the stack was created. Any other space is deleted when the stack is returned.
+	spaceIds []string
+	// baseline holds the resources on the server when the stack was created, which must be unchanged when the
+	// stack is returned
+	baseline spaceexport.Space
+}
+
+// serverCollections are the API collections holding the resources that are not in a space
+var serverCollections = []string{"spaces", "users", "teams", "userroles"}
+
+var stackPool *StackPool
+var stackPoolMutex = sync.Mutex{}
+
+// getStackPool returns the stack pool, or nil if no stack pool has been started
+func getStackPool() *StackPool {
+	stackPoolMutex.Lock()
+	defer stackPoolMutex.Unlock()
+
+	return stackPool
+}
+
+// getStackPoolSize returns the number of stacks kept running by the pool. The default is the
+// number of stacks that can run at once.
+func (o *OctopusContainerTest) getStackPoolSize() int {
+	if o.StackPoolSize > 0 {
+		return o.StackPoolSize
+	}
+
+	if size, err := strconv.Atoi(os.Getenv("OCTOTESTPOOLSIZE")); err == nil && size > 0 {
+		return size
+	}
+
+	return o.getMaxConcurrentStacks()
+}
+
+func (o *OctopusContainerTest) getStackPoolMaxLifetime() time.Duration {
+	if o.StackPoolMaxLifetime > 0 {
+		return o.StackPoolMaxLifetime
+	}
+
+	if lifetime, err := time.ParseDuration(os.Getenv("OCTOTESTPOOLMAXLIFETIME")); err == nil && lifetime > 0 {
+		return lifetime
+	}
+
+	return defaultStackPoolMaxLifetime
+}
+
+// StartStackPool starts creating the stacks in the pool in the background, and returns without waiting for them
+// to boot. Every call to ArrangeTest leases a stack from the pool until StopStackPool is called.
+// Calling StartStackPool when a pool is already running returns the existing pool.
+func (o *OctopusContainerTest) StartStackPool() *StackPool {
+	stackPoolMutex.Lock()
+	defer stackPoolMutex.Unlock()
+
+	if stackPool != nil {
+		return stackPool
+	}
+
+	size := o.getStackPoolSize()
+	ctx, cancel := context.WithCancel(context.Background())
+
+	stackPool = &StackPool{
+		testFramework: o,
+		maxLifetime:   o.getStackPoolMaxLifetime(),
+		ctx:           ctx,
+		cancel:        cancel,
+		idle:          make(chan *pooledStack, size),
+		stacks:        map[*pooledStack]bool{},
+	}
+
+	log.Printf("Starting a pool of %d stacks", size)
+
+	for i := 0; i < size; i++ {
+		stackPool.replace()
+	}
+
+	return stackPool
+}
+
+// StopStackPool removes every stack created by StartStackPool. It is safe to call StopStackPool
+// when no stack pool is running.
+func (o *OctopusContainerTest) StopStackPool() error {
+	stackPoolMutex.Lock()
+	defer stackPoolMutex.Unlock()
+
+	if stackPool == nil {
+		return nil
+	}
+
+	err := stackPool.stop()
+	stackPool = nil
+
+	return err
+}
+
+// RunWithStackPool is designed to be called from TestMain. It starts the stack pool, runs the tests,
+// and removes the stacks. The return value is the exit code to pass to os.Exit.
+//
+//	func TestMain(m *testing.M) {
+//		testFramework := test.OctopusContainerTest{}
+//		os.Exit(testFramework.RunWithStackPool(m))
+//	}
+func (o *OctopusContainerTest) RunWithStackPool(m *testing.M) int {
+	// TestMain must parse the flags itself before testing.Short() can be called
+	if !flag.Parsed() {
+		flag.Parse()
+	}
+
+	// Short tests skip the integration tests, so there is no need for a pool
+	if testing.Short() {
+		return m.Run()
+	}
+
+	o.StartStackPool()
+
+	// A panic in a test ends the process from the test's goroutine, so the deferred function does not run.
+	// The testcontainers reaper removes the containers in that case.
+	defer func() {
+		if err := o.StopStackPool(); err != nil {
+			log.Println("Failed to stop the stack pool: " + err.Error())
+		}
+
+		DeleteDatabaseSnapshots()
+	}()
+
+	return m.Run()
+}
+
+// replace creates a new stack in the background and adds it to the pool. Failed attempts are retried
+// until a stack is created or the pool is stopped.
+func (p *StackPool) replace() {
+	p.creating.Add(1)
+
+	go func() {
+		defer p.creating.Done()
+
+		for {
+			stack, err := p.create()
+			if err == nil {
+				p.idle <- stack
+				return
+			}
+
+			log.Println("Failed to create a stack for the pool: " + err.Error())
+
+			select {
+			case <-p.ctx.Done():
+				return
+			case <-time.After(30 * time.Second):
+			}
+		}
+	}()
+}
+
+// create starts a new stack and waits for the API to respond. The stack holds one of the running stacks allowed by
+// MaxConcurrentStacks until it is removed.
+func (p *StackPool) create() (*pooledStack, error) {
+	if err := p.testFramework.acquireStack(p.ctx); err != nil {
+		return nil, err
+	}
+
+	// Creating the containers is serialized with the stacks created by ArrangeTest,
+	// but the stacks boot concurrently.
+	network, octopusContainer, sqlServer, err := p.testFramework.createDockerInfrastructure(log.Println, p.ctx)
+
+	stack := &pooledStack{
+		OctopusStack: OctopusStack{
+			Container: octopusContainer,
+			SqlServer: sqlServer,
+			Network:   network,
+		},
+		created: time.Now(),
+	}
+
+	p.mutex.Lock()
+	p.stacks[stack] = true
+	p.mutex.Unlock()
+
+	if err == nil {
+		err = p.initialise(stack)
+	}
+
+	if err != nil {
+		return nil, errors.Join(err, p.remove(stack))
+	}
+
+	log.Println("Added the stack " + stack.Container.URI + " to the pool")
+
+	return stack, nil
+}
+
+// initialise waits for a new stack to start, and records the spaces and resources that exist before any test has run
+func (p *StackPool) initialise(stack *pooledStack) error {
+	if err := waitForApi(p.ctx, stack.Container.URI); err != nil {
+		return err
+	}
+
+	// A failed snapshot only means the next stack starts from an empty database
+	if err := p.testFramework.takeDatabaseSnapshot(p.ctx, stack.SqlServer); err != nil {
+		log.Println("Failed to take a snapshot of the database: " + err.Error())
+	}
+
+	octoClient, err := octoclient.CreateClient(stack.Container.URI, "", stack.Container.GetApiKey())
+	if err != nil {
+		return err
+	}
+	stack.Client = octoClient
+
+	stack.spaceIds, err = getSpaceIds(p.ctx, stack.Container.URI, stack.Container.GetApiKey())
+	if err != nil {
+		return err
+	}
+
+	stack.baseline, err = exportServer(p.ctx, stack.Container, stack.spaceIds)
+	return err
+}
+
+// lease waits for a healthy stack to become available, for the context to be cancelled, or for the pool to be stopped
+func (p *StackPool) lease(ctx context.Context) (*pooledStack, error) {
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, phaseError(ctx, "waiting for a stack from the pool", ctx.Err())
+		case <-p.ctx.Done():
+			return nil, errors.New("the stack pool was stopped")
+		case stack := <-p.idle:
+			if p.expired(stack) {
+				log.Println("Replacing the stack " + stack.Container.URI + " as it has exceeded its maximum lifetime")
+				p.recycle(stack)
+				continue
+			}
+
+			healthCtx, cancel := context.WithTimeout(ctx, stackHealthCheckTimeout)
+			err := checkApiContext(healthCtx, stack.Container.URI+"/api")
+			cancel()
+
+			if err != nil {
+				log.Println("Replacing the unhealthy stack " + stack.Container.URI + ": " + err.Error())
+				p.recycle(stack)
+				continue
+			}
+
+			return stack, nil
+		}
+	}
+}
+
+// release deletes the spaces created by the test and returns the stack to the pool. Stacks that can not be reset,
+// that were changed outside the spaces created by the test, or that have exceeded their maximum lifetime, are replaced.
+func (p *StackPool) release(stack *pooledStack) {
+	if p.expired(stack) {
+		p.recycle(stack)
+		return
+	}
+
+	// The stack must be reset even if the test context was cancelled
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(p.ctx), 5*time.Minute)
+	defer cancel()
+
+	if err := resetSpaces(ctx, stack.Container.URI, stack.Container.GetApiKey(), stack.spaceIds); err != nil {
+		log.Println("Replacing the stack " + stack.Container.URI + " as it could not be reset: " + err.Error())
+		p.recycle(stack)
+		return
+	}
+
+	// Changes to the default space or to server level resources, such as users and teams, can not be undone
+	current, err := exportServer(ctx, stack.Container, stack.spaceIds)
+	if err != nil {
+		log.Println("Replacing the stack " + stack.Container.URI + " as it could not be read: " + err.Error())
+		p.recycle(stack)
+		return
+	}
+
+	if differences := spaceexport.Compare(stack.baseline, current); len(differences) != 0 {
+		log.Println("Replacing the stack " + stack.Container.URI + " as the test changed it:\n" + strings.Join(differences, "\n"))
+		p.recycle(stack)
+		return
+	}
+
+	p.idle <- stack
+}
+
+func (p *StackPool) expired(stack *pooledStack) bool {
+	return time.Since(stack.created) > p.maxLifetime
+}
+
+// recycle removes a stack and starts creating its replacement
+func (p *StackPool) recycle(stack *pooledStack) {
+	p.replace()
+
+	go func() {
+		if err := p.remove(stack); err != nil {
+			log.Println("Failed to remove the stack " + stack.Container.URI + ": " + err.Error())
+		}
+	}()
+}
+
+// remove stops and removes the containers and network that make up a stack, and allows another stack to be created.
+// Removing a stack that was already removed does nothing.
+func (p *StackPool) remove(stack *pooledStack) error {
+	p.mutex.Lock()
+	created := p.stacks[stack]
+	delete(p.stacks, stack)
+	p.mutex.Unlock()
+
+	if !created {
+		return nil
+	}
+
+	defer p.testFramework.releaseStack()
+
+	globalMutex.Lock()
+	defer globalMutex.Unlock()
+
+	ctx := context.WithoutCancel(p.ctx)
+	stopTime := 1 * time.Minute
+	var errs []error
+
+	if stack.Container != nil {
+		// This fixes the "can not get logs from container which is dead or marked for removal" error
+		// See https://github.com/testcontainers/testcontainers-go/issues/606
+		if stack.Container.logConsumer != nil {
+			if err := stack.Container.StopLogProducer(); err != nil {
+				log.Println(err)
+			}
+		}
+
+		errs = append(errs, stack.Container.Shutdown(ctx))
+	}
+
+	if stack.SqlServer != nil {
+		if stack.SqlServer.logConsumer != nil {
+			if err := stack.SqlServer.StopLogProducer(); err != nil {
+				log.Println(err)
+			}
+		}
+
+		errs = append(errs, stack.SqlServer.Stop(ctx, &stopTime), stack.SqlServer.Terminate(ctx))
+	}
+
+	if stack.Network != nil {
+		errs = append(errs, stack.Network.Remove(ctx))
+	}
+
+	return errors.Join(errs...)
+}
+
+// stop abandons any stacks being created, and removes every stack in the pool
+func (p *StackPool) stop() error {
+	p.cancel()
+	p.creating.Wait()
+
+	p.mutex.Lock()
+	stacks := []*pooledStack{}
+	for stack := range p.stacks {
+		stacks = append(stacks, stack)
+	}
+	p.mutex.Unlock()
+
+	var errs []error
+	for _, stack := range stacks {
+		errs = append(errs, p.remove(stack))
+	}
+
+	return errors.Join(errs...)
+}
+
+// arrangePooledTest runs a test against a stack leased from the pool. The stack is returned to the pool
+// once every attempt of the test has completed. If no stack can be leased, such as when the pool was stopped,
+// the test creates its own stack instead.
+func (o *OctopusContainerTest) arrangePooledTest(ctx context.Context, t *testing.T, pool *StackPool, testFunc func(ctx context.Context, t *testing.T, container *OctopusContainer, client *client.Client) error) {
+	if testing.Short() {
+		t.Skip("skipping integration test")
+	}
+
+	stack, err := pool.lease(ctx)
+	if err != nil {
+		if ctx.Err() != nil {
+			fatalRedacted(t, err.Error())
+		}
+
+		logRedacted(t, "Creating a new stack as no stack could be leased from the pool: "+err.Error())
+		o.arrangeNewStackTest(ctx, t, testFunc)
+		return
+	}
+	defer pool.release(stack)
+
+	logRedacted(t, "Leased the stack "+stack.Container.URI+" from the pool")
+
+	o.setTestInstance(t, stack.Container, stack.SqlServer)
+	routeContainerLogs(t, stack.Container, stack.SqlServer)
+
+	o.runWithRetries(ctx, t, func() error {
+		return testFunc(ctx, t, stack.Container, stack.Client)
+	})
+}
+
+// getSpaceIds returns the IDs of every space on the server
+func getSpaceIds(ctx context.Context, server string, apiKey string) ([]string, error) {
+	spaces := []map[string]any{}
+	if err := octopusRequest(ctx, http.MethodGet, server+"/api/spaces/all", apiKey, nil, &spaces); err != nil {
+		return nil, phaseError(ctx, "reading the spaces", err)
+	}
+
+	spaceIds := []string{}
+	for _, space := range spaces {
+		if spaceId, ok := space["Id"].(string); ok {
+			spaceIds = append(spaceIds, spaceId)
+		}
+	}
+
+	return spaceIds, nil
+}
+
+// resetSpaces deletes every space that is not in the list of spaces to keep
+func resetSpaces(ctx context.Context, server string, apiKey string, keepSpaceIds []string) error {
+	spaceIds, err := getSpaceIds(ctx, server, apiKey)
+	if err != nil {
+		return err
+	}
+
+	for _, spaceId := range spaceIds {
+		if slices.Contains(keepSpaceIds, spaceId) {
+			continue
+		}
+
+		if err := deleteSpace(ctx, server, apiKey, spaceId); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// exportServer reads the resources in the spaces and the resources that are not in a space. Each space is normalised
+// separately, and its collections are prefixed with the space ID, such as "Spaces-1/environments".
+func exportServer(ctx context.Context, container OctopusInstance, spaceIds []string) (spaceexport.Space, error) {
+	server := spaceexport.Space{}
+	for _, collection := range serverCollections {
+		resources := []map[string]any{}
+		err := octopusRequest(ctx, http.MethodGet, container.GetURI()+"/api/"+collection+"/all", container.GetApiKey(), nil, &resources)
+
+		// Older versions of Octopus do not support every collection
+		if errors.Is(err, errNotFound) {
+			continue
+		}
+
+		if err != nil {
+			return nil, phaseError(ctx, "reading the "+collection, err)
+		}
+
+		server[collection] = resources
+	}
+
+	server = spaceexport.Normalise(server)
+
+	for _, spaceId := range spaceIds {
+		space, err := exportSpace(container, spaceId)
+		if err != nil {
+			return nil, err
+		}
+
+		for collection, resources := range space {
+			server[spaceId+"/"+collection] = resources
+		}
+	}
+
+	return server, nil
+}
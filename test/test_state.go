@@ -0,0 +1,139 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"sync"
+	"testing"
+)
+
+// testState records the actions that must be run once a test function has completed, such as destroying
+// the Terraform modules applied by the test. The actions must run while the Octopus stack is still available,
+// so ArrangeTest runs them as soon as the test function returns. Tests that manage their own containers
+// have the actions run by t.Cleanup instead. The state also holds the files generated for the test, which
+// are removed once the actions have run. Working copies of modules are retained if the test failed.
+// The instance and commands run by the test are recorded for the failure artifacts, and the spaces created by the
+// modules applied by the test are recorded so they can be deleted from servers that outlive the test.
+type testState struct {
+	mutex           sync.Mutex
+	afterTest       []func() error
+	cliConfigDir    string
+	varFilesDir     string
+	workingCopies   map[string]string
+	workingCopyDirs []string
+	instance        OctopusInstance
+	sqlServer       *MysqlContainer
+	commands        []commandRecord
+	createdSpaceIds []string
+}
+
+var testStates = map[*testing.T]*testState{}
+var testStatesMutex = sync.Mutex{}
+
+// getTestState returns the state associated with a test, creating it if necessary
+func getTestState(t *testing.T) *testState {
+	testStatesMutex.Lock()
+	defer testStatesMutex.Unlock()
+
+	state, ok := testStates[t]
+	if !ok {
+		state = &testState{workingCopies: map[string]string{}}
+		testStates[t] = state
+
+		t.Cleanup(func() {
+			if err := state.runAfterTest(); err != nil {
+				errorRedacted(t, err.Error())
+			}
+
+			if state.cliConfigDir != "" {
+				if err := os.RemoveAll(state.cliConfigDir); err != nil {
+					logRedacted(t, "Failed to remove the CLI configuration: "+err.Error())
+				}
+			}
+
+			// The variable files hold the API key, so they are removed even if the test failed
+			if state.varFilesDir != "" {
+				if err := os.RemoveAll(state.varFilesDir); err != nil {
+					logRedacted(t, "Failed to remove the variable files: "+err.Error())
+				}
+			}
+
+			for _, dir := range state.workingCopyDirs {
+				if t.Failed() {
+					logRedacted(t, "Retaining the working copy "+dir+" for debugging")
+				} else if err := os.RemoveAll(dir); err != nil {
+					logRedacted(t, "Failed to remove the working copy "+dir+": "+err.Error())
+				}
+			}
+
+			testStatesMutex.Lock()
+			defer testStatesMutex.Unlock()
+			delete(testStates, t)
+		})
+	}
+
+	return state
+}
+
+// afterTest registers an action to run once the test function has completed. Actions are run
+// in the reverse order to which they were registered.
+func (o *OctopusContainerTest) afterTest(t *testing.T, action func() error) {
+	state := getTestState(t)
+
+	state.mutex.Lock()
+	defer state.mutex.Unlock()
+
+	state.afterTest = append(state.afterTest, action)
+}
+
+// runAfterTest runs and removes any actions registered against the test
+func (o *OctopusContainerTest) runAfterTest(t *testing.T) error {
+	return getTestState(t).runAfterTest()
+}
+
+func (s *testState) runAfterTest() error {
+	s.mutex.Lock()
+	actions := s.afterTest
+	s.afterTest = nil
+	s.mutex.Unlock()
+
+	var errs []error
+	for i := len(actions) - 1; i >= 0; i-- {
+		if err := actions[i](); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// recordCreatedSpace records a space created by a module applied by the test
+func (o *OctopusContainerTest) recordCreatedSpace(t *testing.T, spaceId string) {
+	state := getTestState(t)
+
+	state.mutex.Lock()
+	defer state.mutex.Unlock()
+
+	state.createdSpaceIds = append(state.createdSpaceIds, spaceId)
+}
+
+// deleteCreatedSpaces deletes the spaces recorded by recordCreatedSpace, in the reverse order to which they were created
+func (o *OctopusContainerTest) deleteCreatedSpaces(ctx context.Context, t *testing.T, instance OctopusInstance) error {
+	state := getTestState(t)
+
+	state.mutex.Lock()
+	spaceIds := state.createdSpaceIds
+	state.createdSpaceIds = nil
+	state.mutex.Unlock()
+
+	var errs []error
+	for i := len(spaceIds) - 1; i >= 0; i-- {
+		logRedacted(t, "Deleting the space "+spaceIds[i])
+		if err := deleteSpace(ctx, instance.GetURI(), instance.GetApiKey(), spaceIds[i]); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
@@ -0,0 +1,105 @@
+package test
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"strings"
+	"testing"
+)
+
+/*
+	This file contains functions that pass Terraform variables in a variable file rather than as command line
+	arguments. Variable files keep the values out of the process list, and allow maps, lists, and multi-line
+	strings to be passed without escaping them as HCL.
+*/
+
+// varFilePrefix is the argument that passes a variable file to terraform
+const varFilePrefix = "-var-file="
+
+// TerraformVars writes the variables to a .tfvars.json file, and returns the argument passing the file to terraform.
+// The result can be passed to any function accepting a []string of variables, alongside "-var=name=value" arguments:
+//
+//	vars, err := testFramework.TerraformVars(t, map[string]any{
+//		"environments": []string{"Development", "Test"},
+//		"tags":         map[string]string{"team": "platform"},
+//	})
+//	spaceId, err := testFramework.Act(t, container, "../terraform", "2-simpleexample", vars)
+//
+// The file is removed once the test completes.
+func (o *OctopusContainerTest) TerraformVars(t *testing.T, vars map[string]any) ([]string, error) {
+	content, err := json.MarshalIndent(vars, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	state := getTestState(t)
+	state.mutex.Lock()
+	defer state.mutex.Unlock()
+
+	if state.varFilesDir == "" {
+		state.varFilesDir, err = os.MkdirTemp("", "octoterra_vars")
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	file, err := os.CreateTemp(state.varFilesDir, "*.tfvars.json")
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	// The file is only readable by the current user, as it may hold the API key
+	if err := file.Chmod(0o600); err != nil {
+		return nil, err
+	}
+
+	if _, err := file.Write(content); err != nil {
+		return nil, err
+	}
+
+	return []string{varFilePrefix + file.Name()}, nil
+}
+
+// readVarArgs returns the values defined by "-var=name=value" arguments and the .tfvars.json files passed with
+// "-var-file=". Values read from variable files are compact JSON unless they are strings.
+func readVarArgs(vars []string) map[string]string {
+	values := map[string]string{}
+
+	for _, arg := range vars {
+		if strings.HasPrefix(arg, "-var=") {
+			if name, value, found := strings.Cut(strings.TrimPrefix(arg, "-var="), "="); found {
+				values[name] = value
+			}
+			continue
+		}
+
+		file, ok := strings.CutPrefix(arg, varFilePrefix)
+		if !ok || !strings.HasSuffix(file, ".json") {
+			continue
+		}
+
+		content, err := os.ReadFile(file)
+		if err != nil {
+			continue
+		}
+
+		fileVars := map[string]json.RawMessage{}
+		if err := json.Unmarshal(content, &fileVars); err != nil {
+			continue
+		}
+
+		for name, value := range fileVars {
+			var stringValue string
+			compactValue := bytes.Buffer{}
+			if err := json.Unmarshal(value, &stringValue); err == nil {
+				values[name] = stringValue
+			} else if err := json.Compact(&compactValue, value); err == nil {
+				values[name] = compactValue.String()
+			}
+		}
+	}
+
+	return values
+}
@@ -0,0 +1,116 @@
+package test
+
+import (
+	"os"
+	"strings"
+)
+
+/*
+	This file contains the names of the Terraform variables and outputs that the framework uses to pass the server
+	details to a module, and to read the ID of a new space. Modules following other conventions can be tested
+	without wrapper modules by mapping the values to their own variable names, or by passing the server details
+	to the provider in its environment variables.
+*/
+
+// skipVariable is the name that stops a value being passed as a variable
+const skipVariable = "-"
+
+// TerraformVariableNames maps the values passed by the framework to the variables declared by the modules under test.
+// Names left empty fall back to OCTOTESTVARNAMES, and then to the default names. Set a variable name to "-" to not
+// pass the value as a variable.
+type TerraformVariableNames struct {
+	// Server is the variable holding the Octopus server URL. Defaults to octopus_server.
+	Server string
+	// ApiKey is the variable holding the API key. Defaults to octopus_apikey.
+	ApiKey string
+	// SpaceId is the variable holding the ID of the space being populated. Defaults to octopus_space_id.
+	SpaceId string
+	// SpaceName is the variable holding the name of the space created by the initialisation module. Defaults to octopus_space_name.
+	SpaceName string
+	// SpaceDescription is the variable holding the description of the space created by the initialisation module.
+	// Defaults to octopus_space_description.
+	SpaceDescription string
+	// SpaceIdOutput is the output of the initialisation module holding the ID of the new space. Defaults to octopus_space_id.
+	SpaceIdOutput string
+	// ProviderEnvironment passes the server URL and API key to the provider in the OCTOPUS_URL and OCTOPUS_APIKEY
+	// environment variables. Defaults to OCTOTESTPROVIDERENV.
+	ProviderEnvironment *bool
+}
+
+// defaultVariableNames are the names used by the modules in this repository
+var defaultVariableNames = TerraformVariableNames{
+	Server:           "octopus_server",
+	ApiKey:           "octopus_apikey",
+	SpaceId:          "octopus_space_id",
+	SpaceName:        "octopus_space_name",
+	SpaceDescription: "octopus_space_description",
+	SpaceIdOutput:    "octopus_space_id",
+}
+
+// getVariableNames returns the variable names with every empty name replaced. OCTOTESTVARNAMES is a comma separated
+// list of key=name pairs, where the keys are server, apikey, spaceid, spacename, spacedescription, and spaceidoutput.
+func (o *OctopusContainerTest) getVariableNames() TerraformVariableNames {
+	envNames := map[string]string{}
+	for _, pair := range strings.Split(os.Getenv("OCTOTESTVARNAMES"), ",") {
+		if key, name, found := strings.Cut(pair, "="); found {
+			envNames[strings.ToLower(strings.TrimSpace(key))] = strings.TrimSpace(name)
+		}
+	}
+
+	name := func(setting string, key string, defaultName string) string {
+		if setting != "" {
+			return setting
+		}
+
+		if envName := envNames[key]; envName != "" {
+			return envName
+		}
+
+		return defaultName
+	}
+
+	return TerraformVariableNames{
+		Server:              name(o.VariableNames.Server, "server", defaultVariableNames.Server),
+		ApiKey:              name(o.VariableNames.ApiKey, "apikey", defaultVariableNames.ApiKey),
+		SpaceId:             name(o.VariableNames.SpaceId, "spaceid", defaultVariableNames.SpaceId),
+		SpaceName:           name(o.VariableNames.SpaceName, "spacename", defaultVariableNames.SpaceName),
+		SpaceDescription:    name(o.VariableNames.SpaceDescription, "spacedescription", defaultVariableNames.SpaceDescription),
+		SpaceIdOutput:       name(o.VariableNames.SpaceIdOutput, "spaceidoutput", defaultVariableNames.SpaceIdOutput),
+		ProviderEnvironment: Bool(boolSetting(o.VariableNames.ProviderEnvironment, "OCTOTESTPROVIDERENV", "true")),
+	}
+}
+
+// serverVariables returns the server details keyed by the names of the variables that receive them
+func (n TerraformVariableNames) serverVariables(server string, apiKey string, spaceId string) map[string]any {
+	vars := map[string]any{}
+	for name, value := range map[string]string{n.Server: server, n.ApiKey: apiKey, n.SpaceId: spaceId} {
+		if name != skipVariable {
+			vars[name] = value
+		}
+	}
+
+	return vars
+}
+
+// spaceVarArgs returns the arguments passing the name and description of a new space to the initialisation module
+func (n TerraformVariableNames) spaceVarArgs(spaceName string, spaceDescription string) []string {
+	args := []string{}
+	if n.SpaceName != skipVariable {
+		args = append(args, "-var="+n.SpaceName+"="+spaceName)
+	}
+
+	if n.SpaceDescription != skipVariable {
+		args = append(args, "-var="+n.SpaceDescription+"="+spaceDescription)
+	}
+
+	return args
+}
+
+// providerEnvironment returns the environment variables passing the server details to the provider, if enabled
+func (n TerraformVariableNames) providerEnvironment(server string, apiKey string) []string {
+	if n.ProviderEnvironment == nil || !*n.ProviderEnvironment {
+		return nil
+	}
+
+	return []string{"OCTOPUS_URL=" + server, "OCTOPUS_APIKEY=" + apiKey}
+}
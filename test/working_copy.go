@@ -0,0 +1,165 @@
+package test
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"testing"
+
+	cp "github.com/otiai10/copy"
+)
+
+/*
+	This file contains functions that copy each Terraform module to a temporary directory before it is applied.
+	Applying a copy keeps the .terraform directory, state, and lock files out of the source tree, and allows
+	parallel tests to apply the same module without sharing state.
+*/
+
+// localModuleSource matches module sources that are relative paths
+var localModuleSource = regexp.MustCompile(`(?m)^\s*source\s*=\s*"(\.\.?/[^"]*)"`)
+
+// findLocalModules returns the module directory and every local module it references, directly or indirectly
+func findLocalModules(moduleDir string) ([]string, error) {
+	modules := []string{moduleDir}
+
+	for i := 0; i < len(modules); i++ {
+		files, err := filepath.Glob(filepath.Join(modules[i], "*.tf"))
+		if err != nil {
+			return nil, err
+		}
+
+		for _, file := range files {
+			content, err := os.ReadFile(file)
+			if err != nil {
+				return nil, err
+			}
+
+			for _, match := range localModuleSource.FindAllStringSubmatch(string(content), -1) {
+				source := filepath.Clean(filepath.Join(modules[i], match[1]))
+				if !slices.Contains(modules, source) {
+					modules = append(modules, source)
+				}
+			}
+		}
+	}
+
+	return modules, nil
+}
+
+// commonAncestor returns the deepest directory containing all the supplied absolute directories
+func commonAncestor(dirs []string) string {
+	ancestor := dirs[0]
+
+	for _, dir := range dirs[1:] {
+		for ancestor != filepath.Dir(ancestor) {
+			if rel, err := filepath.Rel(ancestor, dir); err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+				break
+			}
+			ancestor = filepath.Dir(ancestor)
+		}
+	}
+
+	return ancestor
+}
+
+// skipGeneratedFiles excludes the files written by previous terraform runs from a working copy
+func skipGeneratedFiles(srcinfo os.FileInfo, src string, dest string) (bool, error) {
+	name := srcinfo.Name()
+
+	return name == ".terraform" ||
+		name == ".terraform.lock.hcl" ||
+		name == ".terraform.tfstate.lock.info" ||
+		strings.HasPrefix(name, "terraform.tfstate"), nil
+}
+
+// createWorkingCopy copies a module, and any local modules it references, to a temporary directory, returning the
+// path to the copied module. Local modules are copied to the same relative location, so relative module sources
+// resolve correctly. Terraform commands run by the test against the module directory are run against the copy.
+func (o *OctopusContainerTest) createWorkingCopy(t *testing.T, moduleDir string) (string, error) {
+	absModuleDir, err := filepath.Abs(moduleDir)
+	if err != nil {
+		return "", err
+	}
+
+	modules, err := findLocalModules(absModuleDir)
+	if err != nil {
+		return "", err
+	}
+
+	dest, err := os.MkdirTemp("", "octoterra")
+	if err != nil {
+		return "", err
+	}
+
+	state := getTestState(t)
+	state.mutex.Lock()
+	defer state.mutex.Unlock()
+
+	state.workingCopyDirs = append(state.workingCopyDirs, dest)
+
+	root := commonAncestor(modules)
+	for _, module := range modules {
+		rel, err := filepath.Rel(root, module)
+		if err != nil {
+			return "", err
+		}
+
+		if err := cp.Copy(module, filepath.Join(dest, rel), cp.Options{Skip: skipGeneratedFiles}); err != nil {
+			return "", err
+		}
+	}
+
+	rel, err := filepath.Rel(root, absModuleDir)
+	if err != nil {
+		return "", err
+	}
+
+	workingCopy := filepath.Join(dest, rel)
+	state.workingCopies[absModuleDir] = workingCopy
+
+	logRedacted(t, "Applying "+moduleDir+" from the working copy "+workingCopy)
+
+	return workingCopy, nil
+}
+
+// getWorkingCopy returns the most recent working copy of the module created by the test, or the module
+// directory if it has not been copied
+func (o *OctopusContainerTest) getWorkingCopy(t *testing.T, moduleDir string) string {
+	absModuleDir, err := filepath.Abs(moduleDir)
+	if err != nil {
+		return moduleDir
+	}
+
+	state := getTestState(t)
+	state.mutex.Lock()
+	defer state.mutex.Unlock()
+
+	if workingCopy, ok := state.workingCopies[absModuleDir]; ok {
+		return workingCopy
+	}
+
+	return moduleDir
+}
+
+// getSourceModule returns the absolute path of the module a working copy created by the test was copied from, or the
+// absolute path of the directory if it is not a working copy
+func (o *OctopusContainerTest) getSourceModule(t *testing.T, terraformProjectDir string) string {
+	absProjectDir, err := filepath.Abs(terraformProjectDir)
+	if err != nil {
+		return terraformProjectDir
+	}
+
+	state := getTestState(t)
+	state.mutex.Lock()
+	defer state.mutex.Unlock()
+
+	for source, workingCopy := range state.workingCopies {
+		if workingCopy == absProjectDir {
+			return source
+		}
+	}
+
+	return absProjectDir
+}
@@ -1,18 +1,30 @@
 package wait
 
 import (
+	"context"
 	"fmt"
 	"time"
 )
 
 func WaitForResource(callback func() error, timeout time.Duration) error {
+	return WaitForResourceContext(context.Background(), callback, timeout)
+}
+
+// WaitForResourceContext calls the callback until it succeeds, the timeout expires, or the context is cancelled
+func WaitForResourceContext(ctx context.Context, callback func() error, timeout time.Duration) error {
 	start := time.Now()
 	for {
 		err := callback()
 		if err == nil {
 			return nil
 		}
-		time.Sleep(time.Second)
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("server did not reply before the context was cancelled: %w", ctx.Err())
+		case <-time.After(time.Second):
+		}
+
 		now := time.Now()
 		if now.Sub(start) > timeout {
 			break